@@ -21,6 +21,7 @@ import (
 	"github.com/mrusme/overpush/database"
 	"github.com/mrusme/overpush/fiberzap"
 	"github.com/mrusme/overpush/repositories"
+	"github.com/mrusme/overpush/store"
 	"go.uber.org/zap"
 )
 
@@ -171,6 +172,20 @@ func (api *API) AttachRoutes() {
 	api.app.Post("/1/messages.json", handler(api))
 	api.app.Post("/:token", handler(api))
 	api.app.Post("/_internal/submit/:token", handler(api))
+
+	api.app.Get("/1/receipts/:receipt.json", receiptHandler(api))
+	api.app.Post("/1/receipts/cancel_by_tag/:tag.json",
+		receiptCancelByTagHandler(api))
+	api.app.Post("/1/receipts/:receipt/cancel.json", receiptCancelHandler(api))
+
+	api.app.Get("/1/scheduled.json", scheduledHandler(api))
+	api.app.Post("/1/scheduled/:id/cancel.json", scheduledCancelHandler(api))
+
+	api.app.Get("/1/users/:user/subscriptions.json", subscriptionsHandler(api))
+	api.app.Post("/1/users/:user/subscriptions.json",
+		subscriptionRegisterHandler(api))
+	api.app.Post("/1/users/:user/subscriptions/:id/delete.json",
+		subscriptionUnregisterHandler(api))
 }
 
 func (api *API) Run() error {
@@ -214,10 +229,17 @@ func (api *API) Run() error {
 		return(err)
 	}
 
+	var st *store.Store
+	if st, err = store.New(api.cfg, api.log); err != nil {
+		db.Shutdown()
+		return (err)
+	}
+
 	var repos *repositories.Repositories
-	if repos, err = repositories.New(api.cfg, db); err != nil {
+	if repos, err = repositories.New(api.cfg, db, st); err != nil {
+		st.Shutdown()
 		db.Shutdown()
-		return(err)
+		return (err)
 	}
 	api.repos = repos
 
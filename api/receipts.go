@@ -0,0 +1,134 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
+	"go.uber.org/zap"
+)
+
+type tokenRequest struct {
+	Token string `json:"token" form:"token" query:"token"`
+}
+
+func boolToInt(b bool) int {
+	if b == true {
+		return 1
+	}
+	return 0
+}
+
+// appToken returns the application token from either the request body or the
+// query.
+func appToken(c fiber.Ctx) string {
+	var req tokenRequest
+
+	if c.Method() == fiber.MethodPost {
+		if err := c.Bind().Body(&req); err == nil && req.Token != "" {
+			return req.Token
+		}
+	}
+
+	return c.Query("token")
+}
+
+func receiptHandler(api *API) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		token := appToken(c)
+		if token == "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   "Application token is required",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		rcpt, err := api.repos.Receipt.GetReceiptForToken(
+			c.Params("receipt"),
+			token,
+		)
+		if err != nil {
+			api.log.Debug("Could not retrieve receipt", zap.Error(err))
+			return c.Status(fiber.ErrNotFound.Code).JSON(fiber.Map{
+				"error":   "Receipt not found; may be invalid or expired",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"status":                 1,
+			"acknowledged":           boolToInt(rcpt.Acknowledged),
+			"acknowledged_at":        rcpt.AcknowledgedAt,
+			"acknowledged_by":        rcpt.AcknowledgedBy,
+			"acknowledged_by_device": rcpt.AcknowledgedByDevice,
+			"last_delivered_at":      rcpt.LastDeliveredAt,
+			"expired":                boolToInt(rcpt.IsExpired()),
+			"expires_at":             rcpt.ExpiresAt,
+			"called_back":            boolToInt(rcpt.CalledBack),
+			"called_back_at":         rcpt.CalledBackAt,
+			"request":                requestid.FromContext(c),
+		})
+	}
+}
+
+func receiptCancelHandler(api *API) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		token := appToken(c)
+		if token == "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   "Application token is required",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		if err := api.repos.Receipt.CancelReceipt(
+			c.Params("receipt"),
+			token,
+		); err != nil {
+			api.log.Debug("Could not cancel receipt", zap.Error(err))
+			return c.Status(fiber.ErrNotFound.Code).JSON(fiber.Map{
+				"error":   "Receipt not found; may be invalid or expired",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"status":  1,
+			"request": requestid.FromContext(c),
+		})
+	}
+}
+
+func receiptCancelByTagHandler(api *API) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		token := appToken(c)
+		if token == "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   "Application token is required",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		cancelled, err := api.repos.Receipt.CancelReceiptsByTag(
+			token,
+			c.Params("tag"),
+		)
+		if err != nil {
+			api.log.Error("Could not cancel receipts by tag", zap.Error(err))
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"status":   1,
+			"canceled": cancelled,
+			"request":  requestid.FromContext(c),
+		})
+	}
+}
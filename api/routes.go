@@ -17,6 +17,7 @@ import (
 	"github.com/markusmobius/go-dateparser"
 	"github.com/mrusme/overpush/models/application"
 	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/receipt"
 	"github.com/mrusme/overpush/models/user"
 	"github.com/mrusme/overpush/worker"
 	"go.uber.org/zap"
@@ -31,6 +32,8 @@ func handler(api *API) func(c fiber.Ctx) error {
 		var req map[string]interface{}
 		var appFormat string
 		var application application.Application
+		var fields map[string]string = make(map[string]string)
+		var locations map[string]*gabs.Container = make(map[string]*gabs.Container)
 		var err error
 
 		validate := validator.New(validator.WithRequiredStructEnabled())
@@ -162,9 +165,11 @@ func handler(api *API) func(c fiber.Ctx) error {
 		}
 
 		if appFormat == "pushover" {
-			// Nothing yet
+			// Make the message fields available to templates, e.g. `Dedup.Key`
+			if body, err := gabs.ParseJSON(pretty); err == nil {
+				locations["body"] = body
+			}
 		} else {
-			locations := make(map[string]*gabs.Container)
 			locations["body"] = gabs.Wrap(req)
 			var found bool
 			var tmp string
@@ -230,6 +235,52 @@ func handler(api *API) func(c fiber.Ctx) error {
 			msg.URLTitle, found = application.CustomFormat.
 				GetValue(locations, application.CustomFormat.URLTitle)
 
+			tmp, found = application.CustomFormat.
+				GetValue(locations, application.CustomFormat.Retry)
+			if found {
+				msg.Retry, _ = strconv.Atoi(tmp)
+			}
+
+			tmp, found = application.CustomFormat.
+				GetValue(locations, application.CustomFormat.Expire)
+			if found {
+				msg.Expire, _ = strconv.Atoi(tmp)
+			}
+
+			msg.Callback, found = application.CustomFormat.
+				GetValue(locations, application.CustomFormat.Callback)
+
+			msg.Tags, found = application.CustomFormat.
+				GetValue(locations, application.CustomFormat.Tags)
+
+			tmp, found = application.CustomFormat.
+				GetValue(locations, application.CustomFormat.SendAt)
+			if found {
+				dt, err := dateparser.Parse(nil, tmp)
+				if err == nil {
+					msg.SendAt = dt.Time.Unix()
+				}
+			}
+
+			for name, tmplstr := range application.CustomFormat.Fields {
+				tmp, found = application.CustomFormat.
+					GetValue(locations, tmplstr)
+				if found {
+					fields[name] = tmp
+				}
+			}
+
+		}
+
+		if msg.Device != "" && len(user.Devices) > 0 {
+			if _, unknown := user.GetDevices(msg.Device); len(unknown) > 0 {
+				return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+					"error": fmt.Sprintf("device name is not valid for user: %s",
+						strings.Join(unknown, ", ")),
+					"status":  0,
+					"request": requestid.FromContext(c),
+				})
+			}
 		}
 
 		api.log.Debug("Validating request...")
@@ -247,6 +298,165 @@ func handler(api *API) func(c fiber.Ctx) error {
 		msg.ClearInternal()
 		// Set whether message was submitted via /_internal/submit/:token
 		msg.SetViaSubmit(viaSubmit)
+		// Set the time the message was received at, from which its TTL counts if
+		// it has no timestamp
+		msg.SetReceivedAt(time.Now().Unix())
+		// Set the values extracted via CustomFormat.Fields
+		for name, value := range fields {
+			msg.SetField(name, value)
+		}
+
+		idempotencyKey := c.Get("Idempotency-Key")
+		if idempotencyKey == "" && appFormat != "pushover" {
+			idempotencyKey, _ = application.CustomFormat.
+				GetValue(locations, application.CustomFormat.IdempotencyKey)
+		}
+		if idempotencyKey != "" {
+			requestID, err := api.repos.Idempotency.Claim(
+				token,
+				idempotencyKey,
+				requestid.FromContext(c),
+			)
+			if err != nil {
+				api.log.Error("Error claiming idempotency key", zap.Error(err))
+			} else if requestID != requestid.FromContext(c) {
+				api.log.Debug("Request already processed",
+					zap.String("Idempotency-Key", idempotencyKey),
+					zap.String("request", requestID))
+				response, err := api.repos.Idempotency.GetResponse(
+					token,
+					idempotencyKey,
+				)
+				if err != nil {
+					api.log.Error("Error retrieving idempotent response",
+						zap.Error(err))
+				}
+				if len(response) > 0 {
+					c.Set(fiber.HeaderContentType, fiber.MIMEApplicationJSONCharsetUTF8)
+					return c.Send(response)
+				}
+				// The request is still being processed
+				return c.JSON(fiber.Map{
+					"status":  1,
+					"request": requestID,
+				})
+			} else {
+				// Remember the response if the request succeeds, so that it can be
+				// replayed, or release the key if it fails, so that it can be retried
+				defer func() {
+					if c.Response().StatusCode() < fiber.StatusBadRequest {
+						if err := api.repos.Idempotency.SetResponse(
+							token,
+							idempotencyKey,
+							c.Response().Body(),
+						); err != nil {
+							api.log.Error("Error remembering idempotent response",
+								zap.Error(err))
+						}
+						return
+					}
+					if err := api.repos.Idempotency.Release(
+						token,
+						idempotencyKey,
+					); err != nil {
+						api.log.Error("Error releasing idempotency key",
+							zap.Error(err))
+					}
+				}()
+			}
+		}
+
+		if application.Dedup.IsEnabled() == true {
+			dedupKey, _ := application.CustomFormat.
+				GetValue(locations, application.Dedup.Key)
+			duplicate, repeated, err := api.repos.Dedup.IsDuplicate(
+				application.Dedup,
+				dedupKey,
+				*msg,
+			)
+			if err != nil {
+				api.log.Error("Error checking for duplicates", zap.Error(err))
+			} else if duplicate == true {
+				api.log.Debug("Suppressing duplicate message",
+					zap.String("token", token))
+				if viaSubmit == false {
+					if err = api.repos.Application.IncrementStat(
+						"No need when DB",
+						token,
+						"suppressed",
+					); err != nil {
+						api.log.Error("Application stat not increased",
+							zap.String("stat", "suppressed"),
+							zap.Error(err))
+					}
+				}
+				return c.JSON(fiber.Map{
+					"status":     1,
+					"suppressed": true,
+					"request":    requestid.FromContext(c),
+				})
+			} else {
+				// Release the key if the request fails, so that it can be retried
+				original := *msg
+				defer func() {
+					if c.Response().StatusCode() < fiber.StatusBadRequest {
+						return
+					}
+					if err := api.repos.Dedup.Release(
+						application.Dedup,
+						dedupKey,
+						original,
+						repeated,
+					); err != nil {
+						api.log.Error("Error releasing dedup key",
+							zap.Error(err))
+					}
+				}()
+
+				if repeated > 0 && application.Dedup.Counter == true {
+					msg.Message = fmt.Sprintf("%s\n\n(repeated %d times)",
+						msg.Message, repeated)
+				}
+			}
+		}
+
+		var sendAt int64 = msg.GetSendAt(application.ScheduleByTimestamp)
+		var scheduledID string = ""
+		if sendAt > time.Now().Unix() && api.cfg.Testing == false {
+			scheduledID = requestid.FromContext(c)
+		}
+
+		if msg.IsEmergency() == true {
+			if msg.Retry < receipt.MinRetry {
+				return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+					"error": fmt.Sprintf(
+						"retry is required for emergency priority and must be at least %d seconds",
+						receipt.MinRetry),
+					"status":  0,
+					"request": requestid.FromContext(c),
+				})
+			}
+			if msg.Expire <= 0 || msg.Expire > receipt.MaxExpire {
+				return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+					"error": fmt.Sprintf(
+						"expire is required for emergency priority and must be at most %d seconds",
+						receipt.MaxExpire),
+					"status":  0,
+					"request": requestid.FromContext(c),
+				})
+			}
+
+			rcpt, err := api.repos.Receipt.CreateReceipt(*msg, sendAt)
+			if err != nil {
+				api.log.Error("Error creating receipt", zap.Error(err))
+				return c.Status(fiber.ErrInternalServerError.Code).JSON(fiber.Map{
+					"error":   err.Error(),
+					"status":  0,
+					"request": requestid.FromContext(c),
+				})
+			}
+			msg.SetReceipt(rcpt.ID)
+		}
 
 		payload, err := json.Marshal(msg)
 		if err != nil {
@@ -257,7 +467,17 @@ func handler(api *API) func(c fiber.Ctx) error {
 			})
 		}
 
-		task := asynq.NewTask("message", payload, asynq.MaxRetry(5), asynq.Timeout(30*time.Minute))
+		var opts []asynq.Option
+		if scheduledID != "" {
+			opts = append(opts,
+				asynq.TaskID(scheduledID),
+				asynq.ProcessAt(time.Unix(sendAt, 0)))
+		}
+		if expiresAt := msg.GetExpiresAt(); expiresAt > 0 {
+			opts = append(opts, asynq.Deadline(time.Unix(expiresAt, 0)))
+		}
+
+		task := worker.NewMessageTask(payload, opts...)
 		if api.cfg.Testing == false {
 			api.log.Debug("Enqueueing request", zap.ByteString("payload", payload))
 			_, err = api.redis.Enqueue(task)
@@ -268,6 +488,18 @@ func handler(api *API) func(c fiber.Ctx) error {
 					"request": requestid.FromContext(c),
 				})
 			}
+
+			if scheduledID != "" {
+				if err = api.repos.Scheduled.AddScheduled(
+					token,
+					scheduledID,
+					sendAt,
+				); err != nil {
+					api.log.Error("Scheduled message not recorded",
+						zap.String("id", scheduledID),
+						zap.Error(err))
+				}
+			}
 		} else {
 			api.log.Debug("Calling worker directly with request",
 				zap.ByteString("payload", payload))
@@ -299,9 +531,18 @@ func handler(api *API) func(c fiber.Ctx) error {
 			}
 		}
 
-		return c.JSON(fiber.Map{
+		resp := fiber.Map{
 			"status":  1,
 			"request": requestid.FromContext(c),
-		})
+		}
+		if msg.GetReceipt() != "" {
+			resp["receipt"] = msg.GetReceipt()
+		}
+		if scheduledID != "" {
+			resp["scheduled"] = scheduledID
+			resp["send_at"] = sendAt
+		}
+
+		return c.JSON(resp)
 	}
 }
@@ -0,0 +1,78 @@
+package api
+
+import (
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
+	"go.uber.org/zap"
+)
+
+func scheduledHandler(api *API) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		token := appToken(c)
+		if token == "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   "Application token is required",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		scheduled, err := api.repos.Scheduled.GetScheduled(token)
+		if err != nil {
+			api.log.Error("Could not retrieve scheduled messages", zap.Error(err))
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		var items []fiber.Map = []fiber.Map{}
+		for _, s := range scheduled {
+			items = append(items, fiber.Map{
+				"id":       s.ID,
+				"send_at":  s.SendAt,
+				"title":    s.Message.Title,
+				"message":  s.Message.Message,
+				"priority": s.Message.Priority,
+				"device":   s.Message.Device,
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"status":    1,
+			"scheduled": items,
+			"request":   requestid.FromContext(c),
+		})
+	}
+}
+
+func scheduledCancelHandler(api *API) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		token := appToken(c)
+		if token == "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   "Application token is required",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		if err := api.repos.Scheduled.CancelScheduled(
+			token,
+			c.Params("id"),
+		); err != nil {
+			api.log.Debug("Could not cancel scheduled message", zap.Error(err))
+			return c.Status(fiber.ErrNotFound.Code).JSON(fiber.Map{
+				"error":   "Scheduled message not found; may be invalid or delivered",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"status":  1,
+			"request": requestid.FromContext(c),
+		})
+	}
+}
@@ -0,0 +1,264 @@
+package api
+
+import (
+	"strings"
+	"time"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/gofiber/fiber/v3/middleware/requestid"
+	"github.com/mrusme/overpush/models/subscription"
+	"github.com/mrusme/overpush/models/target"
+	"github.com/mrusme/overpush/worker/targets/webpush"
+	"go.uber.org/zap"
+)
+
+type subscriptionKeys struct {
+	P256dh string `json:"p256dh" form:"p256dh"`
+	Auth   string `json:"auth" form:"auth"`
+}
+
+// subscriptionRequest accepts the output of the browser's
+// `PushSubscription.toJSON()` either as `subscription` or spread into the
+// request, along with the target and a name for the subscription.
+type subscriptionRequest struct {
+	Target   string           `json:"target" form:"target"`
+	Name     string           `json:"name" form:"name"`
+	Endpoint string           `json:"endpoint" form:"endpoint"`
+	Keys     subscriptionKeys `json:"keys"`
+	subscriptionKeys
+
+	Subscription *struct {
+		Endpoint string           `json:"endpoint"`
+		Keys     subscriptionKeys `json:"keys"`
+	} `json:"subscription"`
+}
+
+// subscriptionUser returns the user key of the request if it belongs to an
+// active user and the request carries the token of one of the user's active
+// applications, and sends the error response otherwise.
+func subscriptionUser(api *API, c fiber.Ctx) (string, bool, error) {
+	userKey := c.Params("user")
+	token := appToken(c)
+	if token == "" {
+		return "", false, c.Status(fiber.ErrUnauthorized.Code).JSON(fiber.Map{
+			"error":   "Application token is required",
+			"status":  0,
+			"request": requestid.FromContext(c),
+		})
+	}
+
+	usr, err := api.repos.User.GetUserFromToken(token)
+	if err != nil || usr.Enable == false || usr.Key != userKey {
+		api.log.Debug("Could not retrieve user", zap.Error(err))
+		return "", false, c.Status(fiber.ErrUnauthorized.Code).JSON(fiber.Map{
+			"error":   "No active user with supplied key and token",
+			"status":  0,
+			"request": requestid.FromContext(c),
+		})
+	}
+
+	app, err := api.repos.Application.GetApplication(usr.Key, token)
+	if err != nil || app.Enable == false {
+		api.log.Debug("Could not retrieve application", zap.Error(err))
+		return "", false, c.Status(fiber.ErrUnauthorized.Code).JSON(fiber.Map{
+			"error":   "No active application with supplied token",
+			"status":  0,
+			"request": requestid.FromContext(c),
+		})
+	}
+
+	return usr.Key, true, nil
+}
+
+// getWebPushTargets returns the enabled `webpush` targets.
+func getWebPushTargets(api *API) ([]target.Target, error) {
+	tgts, err := api.repos.Target.GetTargets()
+	if err != nil {
+		return nil, err
+	}
+
+	var webpushTgts []target.Target
+	for _, tgt := range tgts {
+		if tgt.Type == "webpush" && tgt.Enable == true {
+			webpushTgts = append(webpushTgts, tgt)
+		}
+	}
+
+	return webpushTgts, nil
+}
+
+func subscriptionsHandler(api *API) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		userKey, ok, err := subscriptionUser(api, c)
+		if !ok {
+			return err
+		}
+
+		subscriptions, err := api.repos.Subscription.GetSubscriptions(userKey, "")
+		if err != nil {
+			api.log.Error("Could not retrieve subscriptions", zap.Error(err))
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		var items []fiber.Map = []fiber.Map{}
+		for _, s := range subscriptions {
+			items = append(items, fiber.Map{
+				"id":         s.ID,
+				"target":     s.TargetID,
+				"name":       s.Name,
+				"endpoint":   s.Endpoint,
+				"created_at": s.CreatedAt,
+			})
+		}
+
+		tgts, err := getWebPushTargets(api)
+		if err != nil {
+			api.log.Error("Could not retrieve targets", zap.Error(err))
+		}
+		var keys fiber.Map = fiber.Map{}
+		for _, tgt := range tgts {
+			if key, err := webpush.GetPublicKey(tgt); err == nil {
+				keys[tgt.ID] = key
+			}
+		}
+
+		return c.JSON(fiber.Map{
+			"status":        1,
+			"subscriptions": items,
+			"vapid_keys":    keys,
+			"request":       requestid.FromContext(c),
+		})
+	}
+}
+
+func subscriptionRegisterHandler(api *API) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		userKey, ok, err := subscriptionUser(api, c)
+		if !ok {
+			return err
+		}
+
+		var req subscriptionRequest
+		if err := c.Bind().Body(&req); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		s := subscription.Subscription{
+			Name:      strings.TrimSpace(req.Name),
+			Endpoint:  req.Endpoint,
+			P256dh:    req.Keys.P256dh,
+			Auth:      req.Keys.Auth,
+			CreatedAt: time.Now().Unix(),
+		}
+		if req.Subscription != nil {
+			s.Endpoint = req.Subscription.Endpoint
+			s.P256dh = req.Subscription.Keys.P256dh
+			s.Auth = req.Subscription.Keys.Auth
+		}
+		if s.P256dh == "" && s.Auth == "" {
+			s.P256dh = req.subscriptionKeys.P256dh
+			s.Auth = req.subscriptionKeys.Auth
+		}
+		if err := s.Validate(); err != nil {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+		s.ID = subscription.GetID(s.Endpoint)
+
+		tgts, err := getWebPushTargets(api)
+		if err != nil {
+			api.log.Error("Could not retrieve targets", zap.Error(err))
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+		var tgt target.Target
+		for _, t := range tgts {
+			if req.Target == t.ID || (req.Target == "" && len(tgts) == 1) {
+				tgt = t
+				s.TargetID = t.ID
+			}
+		}
+		if s.TargetID == "" {
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   "Target must be the ID of an enabled webpush target",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		if err := webpush.CheckEndpoint(tgt, s.Endpoint); err != nil {
+			api.log.Debug("Rejected subscription endpoint",
+				zap.String("Endpoint", s.Endpoint),
+				zap.Error(err))
+			return c.Status(fiber.ErrBadRequest.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		if err := api.repos.Subscription.SaveSubscription(userKey, s); err != nil {
+			api.log.Error("Could not save subscription", zap.Error(err))
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"status":  1,
+			"id":      s.ID,
+			"target":  s.TargetID,
+			"request": requestid.FromContext(c),
+		})
+	}
+}
+
+func subscriptionUnregisterHandler(api *API) func(c fiber.Ctx) error {
+	return func(c fiber.Ctx) error {
+		userKey, ok, err := subscriptionUser(api, c)
+		if !ok {
+			return err
+		}
+
+		removed, err := api.repos.Subscription.RemoveSubscription(
+			userKey,
+			c.Params("id"),
+		)
+		if err != nil {
+			api.log.Error("Could not remove subscription", zap.Error(err))
+			return c.Status(fiber.ErrInternalServerError.Code).JSON(fiber.Map{
+				"error":   err.Error(),
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+		if removed == false {
+			return c.Status(fiber.ErrNotFound.Code).JSON(fiber.Map{
+				"error":   "Subscription not found",
+				"status":  0,
+				"request": requestid.FromContext(c),
+			})
+		}
+
+		return c.JSON(fiber.Map{
+			"status":  1,
+			"request": requestid.FromContext(c),
+		})
+	}
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gofiber/fiber/v3"
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/user"
+	"github.com/mrusme/overpush/repositories"
+	appRepo "github.com/mrusme/overpush/repositories/application"
+	userRepo "github.com/mrusme/overpush/repositories/user"
+	"go.uber.org/zap"
+)
+
+func newTestAPI(t *testing.T) *API {
+	t.Helper()
+
+	cfg := new(config.Config)
+	cfg.Users = []user.User{
+		{
+			Enable: true,
+			Key:    "alice",
+			Applications: []application.Application{
+				{Enable: true, Token: "alice-app"},
+				{Enable: false, Token: "alice-disabled"},
+			},
+		},
+		{
+			Enable: true,
+			Key:    "bob",
+			Applications: []application.Application{
+				{Enable: true, Token: "bob-app"},
+			},
+		},
+	}
+
+	api := new(API)
+	api.cfg = cfg
+	api.log = zap.NewNop()
+	api.repos = new(repositories.Repositories)
+	api.repos.User, _ = userRepo.New(cfg, nil)
+	api.repos.Application, _ = appRepo.New(cfg, nil)
+
+	api.app = fiber.New()
+	api.app.Get("/1/users/:user/subscriptions.json", subscriptionsHandler(api))
+	api.app.Post("/1/users/:user/subscriptions.json",
+		subscriptionRegisterHandler(api))
+	api.app.Post("/1/users/:user/subscriptions/:id/delete.json",
+		subscriptionUnregisterHandler(api))
+
+	return api
+}
+
+func TestSubscriptionsAuthorization(t *testing.T) {
+	api := newTestAPI(t)
+
+	const list = "/1/users/alice/subscriptions.json"
+	const remove = "/1/users/alice/subscriptions/abc/delete.json"
+	const endpoint = `"endpoint":"https://fcm.googleapis.com/fcm/send/x"`
+
+	for _, tc := range []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		want   int
+	}{
+		{"list without token", http.MethodGet, list, "",
+			fiber.StatusUnauthorized},
+		{"list with token of other user", http.MethodGet,
+			list + "?token=bob-app", "", fiber.StatusUnauthorized},
+		{"list with disabled application", http.MethodGet,
+			list + "?token=alice-disabled", "", fiber.StatusUnauthorized},
+		{"list with unknown token", http.MethodGet,
+			list + "?token=mallory", "", fiber.StatusUnauthorized},
+		{"register without token", http.MethodPost, list,
+			`{` + endpoint + `}`, fiber.StatusUnauthorized},
+		{"register with token of other user", http.MethodPost, list,
+			`{"token":"bob-app",` + endpoint + `}`, fiber.StatusUnauthorized},
+		{"delete without token", http.MethodPost, remove, "",
+			fiber.StatusUnauthorized},
+		{"delete with token of other user", http.MethodPost, remove,
+			`{"token":"bob-app"}`, fiber.StatusUnauthorized},
+		// Passes authorization and is rejected for lack of an endpoint
+		{"register with token", http.MethodPost, list,
+			`{"token":"alice-app"}`, fiber.StatusBadRequest},
+	} {
+		req := httptest.NewRequest(tc.method, tc.path, strings.NewReader(tc.body))
+		if tc.body != "" {
+			req.Header.Set("Content-Type", "application/json")
+		}
+		resp, err := api.app.Test(req)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if resp.StatusCode != tc.want {
+			t.Errorf("%s: status = %d, want %d",
+				tc.name, resp.StatusCode, tc.want)
+		}
+	}
+}
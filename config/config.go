@@ -137,6 +137,16 @@ func (cfg *Config) GetUserFromToken(token string) (user.User, error) {
 	return user.User{}, errors.New("No user key for token found")
 }
 
+func (cfg *Config) GetUserFromKey(key string) (user.User, error) {
+	for _, user := range cfg.Users {
+		if user.Key == key {
+			return user, nil
+		}
+	}
+
+	return user.User{}, errors.New("No user for key found")
+}
+
 func (cfg *Config) GetApplication(userKey string, token string) (application.Application, error) {
 	for _, user := range cfg.Users {
 		if user.Key == userKey {
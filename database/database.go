@@ -9,6 +9,8 @@ import (
 	"github.com/jackc/pgx/v5/pgxpool"
 	"github.com/mrusme/overpush/config"
 	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/quiethours"
+	"github.com/mrusme/overpush/models/subscription"
 	"github.com/mrusme/overpush/models/target"
 	"github.com/mrusme/overpush/models/user"
 	pgxUUID "github.com/vgarvardt/pgx-google-uuid/v5"
@@ -24,8 +26,9 @@ type Database struct {
 }
 
 var (
-	APPLICATION_FIELDS = "enable,token,name,icon_path,format,custom_format,encryption_type,encryption_recipients,encrypt_title,encrypt_message,encrypt_attachment,target_id as target,target_args"
-	TARGET_FIELDS      = "id,enable,type,args"
+	APPLICATION_FIELDS  = "enable,token,name,icon_path,format,custom_format,encryption_type,encryption_recipients,encrypt_title,encrypt_message,encrypt_attachment,target_id as target,targets,target_args,fallbacks,rules,schedule_by_timestamp,quiet_hours,digest,dedup"
+	TARGET_FIELDS       = "id,enable,type,args,max_retry,timeout,retry_backoff,retry_backoff_max"
+	SUBSCRIPTION_FIELDS = "webpush_subscriptions.id,target_id,name,endpoint,p256dh,auth,extract(epoch from created_at)::bigint as created_at"
 )
 
 func New(cfg *config.Config, log *zap.Logger) (*Database, error) {
@@ -145,12 +148,14 @@ func (db *Database) GetUserFromToken(token string) (user.User, error) {
 	var userID string
 	var enable bool
 	var key string
+	var devices []user.Device
+	var quietHours []quiethours.Window
 
 	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
 	if err := db.pool.QueryRow(ctx,
-		"SELECT users.id,users.key,users.enable FROM applications JOIN users ON applications.user_id = users.id WHERE applications.token = $1",
+		"SELECT users.id,users.key,users.enable,users.devices,users.quiet_hours FROM applications JOIN users ON applications.user_id = users.id WHERE applications.token = $1",
 		token,
-	).Scan(&userID, &key, &enable); err != nil {
+	).Scan(&userID, &key, &enable, &devices, &quietHours); err != nil {
 		return user.User{}, err
 	}
 
@@ -163,11 +168,107 @@ func (db *Database) GetUserFromToken(token string) (user.User, error) {
 		Enable:       enable,
 		Key:          key,
 		Applications: applications,
+		Devices:      devices,
+		QuietHours:   quietHours,
 	}
 
 	return user, nil
 }
 
+func (db *Database) GetUserFromKey(key string) (user.User, error) {
+	if db.cfg.Database.Enable == false {
+		return user.User{}, nil
+	}
+
+	var userID string
+	var enable bool
+	var devices []user.Device
+	var quietHours []quiethours.Window
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := db.pool.QueryRow(ctx,
+		"SELECT users.id,users.enable,users.devices,users.quiet_hours FROM users WHERE users.key = $1",
+		key,
+	).Scan(&userID, &enable, &devices, &quietHours); err != nil {
+		return user.User{}, err
+	}
+
+	applications, err := db.GetApplicationsForUser(userID)
+	if err != nil {
+		return user.User{}, err
+	}
+
+	user := user.User{
+		Enable:       enable,
+		Key:          key,
+		Applications: applications,
+		Devices:      devices,
+		QuietHours:   quietHours,
+	}
+
+	return user, nil
+}
+
+func (db *Database) GetSubscriptions(
+	userKey string,
+) ([]subscription.Subscription, error) {
+	if db.cfg.Database.Enable == false {
+		return []subscription.Subscription{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	rows, err := db.pool.Query(ctx,
+		"SELECT "+SUBSCRIPTION_FIELDS+" FROM webpush_subscriptions JOIN users ON webpush_subscriptions.user_id = users.id WHERE users.key = $1 ORDER BY created_at",
+		userKey,
+	)
+	if err != nil {
+		return []subscription.Subscription{}, err
+	}
+
+	subscriptions, err := pgx.CollectRows[subscription.Subscription](
+		rows,
+		pgx.RowToStructByName[subscription.Subscription],
+	)
+	if err != nil {
+		return []subscription.Subscription{}, err
+	}
+
+	return subscriptions, nil
+}
+
+func (db *Database) SaveSubscription(
+	userKey string,
+	s subscription.Subscription,
+) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tag, err := db.pool.Exec(ctx,
+		"INSERT INTO webpush_subscriptions (id,user_id,target_id,name,endpoint,p256dh,auth,created_at) SELECT $1, users.id, $2, $3, $4, $5, $6, to_timestamp($7) FROM users WHERE users.key = $8 ON CONFLICT (user_id, id) DO UPDATE SET target_id = EXCLUDED.target_id, name = EXCLUDED.name, p256dh = EXCLUDED.p256dh, auth = EXCLUDED.auth",
+		s.ID, s.TargetID, s.Name, s.Endpoint, s.P256dh, s.Auth, s.CreatedAt,
+		userKey)
+	if err != nil {
+		return err
+	}
+	if tag.RowsAffected() == 0 {
+		return errors.New("User not found")
+	}
+	return nil
+}
+
+func (db *Database) RemoveSubscription(userKey string, id string) (bool, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	tag, err := db.pool.Exec(ctx,
+		"DELETE FROM webpush_subscriptions USING users WHERE webpush_subscriptions.user_id = users.id AND users.key = $1 AND webpush_subscriptions.id = $2",
+		userKey, id)
+	if err != nil {
+		return false, err
+	}
+	return tag.RowsAffected() > 0, nil
+}
+
 func (db *Database) GetTargets() ([]target.Target, error) {
 	if db.cfg.Database.Enable == false {
 		return []target.Target{}, nil
@@ -197,26 +298,27 @@ func (db *Database) GetTargetByID(targetID string) (target.Target, error) {
 		return target.Target{}, nil
 	}
 
-	var enable bool
-	var targetType string
-	var targetArgs map[string]interface{}
-
 	ctx, _ := context.WithTimeout(context.Background(), 5*time.Second)
-	if err := db.pool.QueryRow(ctx,
+	rows, err := db.pool.Query(ctx,
 		"SELECT "+TARGET_FIELDS+" FROM targets WHERE id = $1",
 		targetID,
-	).Scan(&targetID, &enable, &targetType, &targetArgs); err != nil {
+	)
+	if err != nil {
 		return target.Target{}, err
 	}
 
-	target := target.Target{
-		Enable: enable,
-		ID:     targetID,
-		Type:   targetType,
-		Args:   targetArgs,
+	targets, err := pgx.CollectRows[target.Target](
+		rows,
+		pgx.RowToStructByName[target.Target],
+	)
+	if err != nil {
+		return target.Target{}, err
+	}
+	if len(targets) == 0 {
+		return target.Target{}, errors.New("Target not found")
 	}
 
-	return target, nil
+	return targets[0], nil
 }
 
 func (db *Database) IncrementStat(
@@ -2,9 +2,11 @@ package helpers
 
 import (
 	"bytes"
+	"encoding/json"
 	"errors"
 	"fmt"
 	"html/template"
+	texttemplate "text/template"
 )
 
 type Errors map[string]error
@@ -17,13 +19,13 @@ func ErrorsToError(errs Errors) error {
 	errstr := ""
 
 	for key, err := range errs {
-		fmt.Sprintf("%s[%s] \n", errstr, key, err.Error())
+		errstr = fmt.Sprintf("%s[%s] %s\n", errstr, key, err.Error())
 	}
 	return errors.New(errstr)
 }
 
-func GetFieldValue(tmplstr string, args map[string]interface{}) (string, bool) {
-	funcs := template.FuncMap{
+func templateFuncs(args map[string]interface{}) map[string]any {
+	return map[string]any{
 		"arg": func(arg string) any {
 			val, ok := args[arg].(string)
 			if !ok {
@@ -33,6 +35,10 @@ func GetFieldValue(tmplstr string, args map[string]interface{}) (string, bool) {
 			return val
 		},
 	}
+}
+
+func GetFieldValue(tmplstr string, args map[string]interface{}) (string, bool) {
+	funcs := template.FuncMap(templateFuncs(args))
 
 	tmpl, err := template.New("field").Funcs(funcs).Parse(tmplstr)
 	if err != nil {
@@ -47,3 +53,61 @@ func GetFieldValue(tmplstr string, args map[string]interface{}) (string, bool) {
 
 	return buf.String(), true
 }
+
+// RenderTemplate renders the plain-text template with the given data, offering
+// the same `arg` function as GetFieldValue, as well as `json`, which encodes
+// its argument as JSON (e.g. `{{ json .Message }}` for a quoted string).
+func RenderTemplate(
+	tmplstr string,
+	args map[string]interface{},
+	data any,
+) (string, error) {
+	funcs := texttemplate.FuncMap(templateFuncs(args))
+	funcs["json"] = func(v any) (string, error) {
+		b, err := json.Marshal(v)
+		return string(b), err
+	}
+
+	tmpl, err := texttemplate.New("field").Funcs(funcs).Parse(tmplstr)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
+
+// GetArg returns the argument as string, converting non-string values (e.g.
+// numbers or booleans from the config) to their string representation.
+func GetArg(args map[string]interface{}, name string) (string, bool) {
+	val, ok := args[name]
+	if !ok || val == nil {
+		return "", false
+	}
+
+	if casted, ok := val.(string); ok {
+		return casted, true
+	}
+
+	return fmt.Sprint(val), true
+}
+
+// Truncate shortens the string to at most `max` characters, ending it with an
+// ellipsis if it was shortened.
+func Truncate(s string, max int) string {
+	runes := []rune(s)
+	if len(runes) <= max {
+		return s
+	}
+	if max <= 0 {
+		return ""
+	}
+	if max == 1 {
+		return string(runes[:max])
+	}
+	return string(runes[:max-1]) + "…"
+}
@@ -0,0 +1,23 @@
+package helpers
+
+import "testing"
+
+func TestTruncate(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"hello", 10, "hello"},
+		{"hello", 5, "hello"},
+		{"hello", 4, "hel…"},
+		{"hello", 1, "h"},
+		{"hello", 0, ""},
+		{"hello", -8996, ""},
+		{"hällö", 3, "hä…"},
+	} {
+		if got := Truncate(tc.s, tc.max); got != tc.want {
+			t.Errorf("Truncate(%q, %d) = %q, want %q", tc.s, tc.max, got, tc.want)
+		}
+	}
+}
@@ -1,5 +1,12 @@
 package application
 
+import (
+	"strings"
+
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/quiethours"
+)
+
 type Application struct {
 	Enable       bool
 	Token        string
@@ -15,5 +22,148 @@ type Application struct {
 	EncryptAttachment    bool
 
 	Target     string
+	Targets    []string
 	TargetArgs map[string]interface{}
+
+	Fallbacks []Fallback
+	Rules     []Rule
+
+	// ScheduleByTimestamp delays messages with a future `timestamp` until then
+	ScheduleByTimestamp bool
+
+	QuietHours []quiethours.Window
+
+	Digest Digest
+	Dedup  Dedup
+}
+
+// GetTargetIDs returns the IDs of all targets the application should deliver
+// to, starting with `Target`, followed by `Targets`.
+func (app *Application) GetTargetIDs() []string {
+	var ids []string
+	seen := make(map[string]bool)
+
+	for _, id := range append([]string{app.Target}, app.Targets...) {
+		if id == "" || seen[id] == true {
+			continue
+		}
+		seen[id] = true
+		ids = append(ids, id)
+	}
+
+	return ids
+}
+
+// GetTargetArgs returns the args for a specific target. Per-target args are
+// stored in `TargetArgs` keyed by target ID:
+// { "target1-id": { // config }, "target2-id": { // config } }
+// If there are no args for the target ID, `TargetArgs` is returned as is, for
+// single-target setups.
+func (app *Application) GetTargetArgs(targetID string) map[string]interface{} {
+	if args, ok := lookupTargetArgs(app.TargetArgs, targetID); ok {
+		return args
+	}
+
+	return app.TargetArgs
+}
+
+// lookupTargetArgs returns the args keyed by the target ID, if any.
+func lookupTargetArgs(
+	targetArgs map[string]interface{},
+	targetID string,
+) (map[string]interface{}, bool) {
+	// Note: Viper lower-cases all keys when reading the config.
+	for _, key := range []string{targetID, strings.ToLower(targetID)} {
+		if val, ok := targetArgs[key]; ok {
+			if args, ok := val.(map[string]interface{}); ok {
+				return args, true
+			}
+		}
+	}
+
+	return nil, false
+}
+
+// isPerTarget reports whether the args are keyed per target ID, which is the
+// case if any of the target IDs has args of its own.
+func isPerTarget(targetArgs map[string]interface{}, targetIDs []string) bool {
+	for _, id := range targetIDs {
+		if _, ok := lookupTargetArgs(targetArgs, id); ok {
+			return true
+		}
+	}
+
+	return false
+}
+
+// GetRoutes evaluates the application's rules and returns the targets the
+// message should be delivered to. If no rule matches, the message is routed to
+// the application's targets. Rule errors (e.g. invalid regular expressions)
+// are returned alongside the routes, with the faulty rule being skipped.
+func (app *Application) GetRoutes(m message.Message) ([]Route, error) {
+	var routes []Route
+	var rerr error
+	var matched bool = false
+	seen := make(map[string]bool)
+
+	add := func(targetIDs []string, targetArgs map[string]interface{}) {
+		perTarget := isPerTarget(targetArgs, targetIDs)
+		for _, id := range targetIDs {
+			if id == "" || seen[id] == true {
+				continue
+			}
+			seen[id] = true
+
+			// Rule args that are keyed per target only replace the application's
+			// args for the targets they have an entry for.
+			args := app.GetTargetArgs(id)
+			if len(targetArgs) > 0 && perTarget == false {
+				args = targetArgs
+			} else if ruleArgs, ok := lookupTargetArgs(targetArgs, id); ok {
+				args = ruleArgs
+			}
+			routes = append(routes, Route{TargetID: id, TargetArgs: args})
+		}
+	}
+
+	for _, rule := range app.Rules {
+		ok, err := rule.Matches(m)
+		if err != nil {
+			rerr = err
+			continue
+		}
+		if !ok {
+			continue
+		}
+
+		matched = true
+		if rule.Drop == true {
+			return []Route{}, rerr
+		}
+
+		if len(rule.Targets) > 0 {
+			add(rule.Targets, rule.TargetArgs)
+		} else {
+			add(app.GetTargetIDs(), rule.TargetArgs)
+		}
+
+		if rule.Continue == false {
+			break
+		}
+	}
+
+	if matched == false {
+		add(app.GetTargetIDs(), nil)
+	}
+
+	return routes, rerr
+}
+
+// GetFallback returns the n-th (starting at 1) fallback of the chain.
+func (app *Application) GetFallback(n int) (Fallback, bool) {
+	if n < 1 || n > len(app.Fallbacks) {
+		return Fallback{}, false
+	}
+
+	return app.Fallbacks[n-1], true
 }
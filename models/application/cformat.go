@@ -22,6 +22,20 @@ type CFormat struct {
 	Title            string
 	URL              string
 	URLTitle         string
+
+	Retry    string
+	Expire   string
+	Callback string
+	Tags     string
+	SendAt   string
+
+	// IdempotencyKey identifies retried requests, like the `Idempotency-Key`
+	// header
+	IdempotencyKey string
+
+	// Fields are additional named values that are extracted from the webhook
+	// for use in `Rules`
+	Fields map[string]string
 }
 
 func (cf *CFormat) GetLocationAndPath(str string) (string, string) {
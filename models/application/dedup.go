@@ -0,0 +1,34 @@
+package application
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/mrusme/overpush/models/message"
+)
+
+// Dedup suppresses messages that are identical to one that was delivered
+// within the last Window seconds. Messages are identified by Key, a template
+// like the ones of `CustomFormat` (e.g. `{{ webhook "body.alerts.0.fingerprint" }}`),
+// or by their title and message if Key is empty or renders empty. With Counter
+// enabled, the next delivered message mentions how often it was suppressed.
+type Dedup struct {
+	Window  int
+	Key     string
+	Counter bool
+}
+
+func (dd *Dedup) IsEnabled() bool {
+	return dd.Window > 0
+}
+
+// GetHash returns the hash that identifies the message, based on the rendered
+// Key if it's not empty.
+func (dd *Dedup) GetHash(key string, m message.Message) string {
+	if key == "" {
+		key = m.Title + "\n" + m.Message
+	}
+
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
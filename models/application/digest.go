@@ -0,0 +1,106 @@
+package application
+
+import (
+	"bytes"
+	"text/template"
+	"time"
+
+	"github.com/mrusme/overpush/models/message"
+)
+
+const (
+	DEFAULT_DIGEST_TITLE    = `{{ .Application }}: {{ len .Messages }} messages`
+	DEFAULT_DIGEST_TEMPLATE = `{{ range .Messages }}{{ .ToString }}
+{{ end }}`
+)
+
+// Digest accumulates the application's messages with a priority of at most
+// MaxPriority and delivers them as one combined message every Interval
+// seconds. Title and Template are text templates that are being rendered with
+// the application's name (`.Application`) and the accumulated messages
+// (`.Messages`), e.g. `{{ range .Messages }}{{ .ToString }}{{ end }}`.
+type Digest struct {
+	Interval    int
+	MaxPriority int
+	Title       string
+	Template    string
+}
+
+// Accepts reports whether the message should be added to the digest.
+// Emergency-priority messages are never being accumulated.
+func (dg *Digest) Accepts(m message.Message) bool {
+	return dg.Interval > 0 &&
+		m.IsDigest() == false &&
+		m.IsEmergency() == false &&
+		m.Priority <= dg.MaxPriority
+}
+
+// NextAt returns the end of the interval the given time lies in, at which the
+// digest is due. Intervals are aligned to the Unix epoch, so that all workers
+// agree on them.
+func (dg *Digest) NextAt(now time.Time) time.Time {
+	interval := int64(dg.Interval)
+	if interval <= 0 {
+		return now
+	}
+	return time.Unix((now.Unix()/interval+1)*interval, 0)
+}
+
+// Render combines the accumulated messages into a single message.
+func (dg *Digest) Render(
+	appName string,
+	msgs []message.Message,
+) (message.Message, error) {
+	var m message.Message
+
+	data := struct {
+		Application string
+		Messages    []*message.Message
+	}{
+		Application: appName,
+	}
+	for i := range msgs {
+		data.Messages = append(data.Messages, &msgs[i])
+		if i == 0 || msgs[i].Priority > m.Priority {
+			m.Priority = msgs[i].Priority
+		}
+	}
+	if len(msgs) > 0 {
+		m.Token = msgs[0].Token
+		m.User = msgs[0].User
+	}
+
+	var err error
+	if m.Title, err = renderDigest(dg.Title, DEFAULT_DIGEST_TITLE, data); err != nil {
+		return m, err
+	}
+	if m.Message, err = renderDigest(
+		dg.Template,
+		DEFAULT_DIGEST_TEMPLATE,
+		data,
+	); err != nil {
+		return m, err
+	}
+
+	m.Timestamp = time.Now().Unix()
+	m.SetDigest(true)
+	return m, nil
+}
+
+func renderDigest(tmplstr string, fallback string, data any) (string, error) {
+	if tmplstr == "" {
+		tmplstr = fallback
+	}
+
+	tmpl, err := template.New("digest").Parse(tmplstr)
+	if err != nil {
+		return "", err
+	}
+
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+
+	return buf.String(), nil
+}
@@ -0,0 +1,29 @@
+package application
+
+import "time"
+
+// Fallback is an entry in an application's ordered fallback chain. When a
+// delivery fails, the message is handed to the next fallback in the chain
+// once AfterFailedAttempts attempts have failed or AfterSeconds have passed
+// since the first attempt, whichever comes first. A delivery that has
+// exhausted its retries always falls back.
+type Fallback struct {
+	Target              string
+	AfterFailedAttempts int
+	AfterSeconds        int
+}
+
+// ShouldFallBack reports whether a delivery that has failed `failed` times and
+// that started at `startedAt` should be handed to this fallback.
+func (fb *Fallback) ShouldFallBack(failed int, startedAt time.Time) bool {
+	if fb.AfterFailedAttempts > 0 && failed >= fb.AfterFailedAttempts {
+		return true
+	}
+
+	if fb.AfterSeconds > 0 &&
+		time.Since(startedAt) >= time.Duration(fb.AfterSeconds)*time.Second {
+		return true
+	}
+
+	return false
+}
@@ -0,0 +1,100 @@
+package application
+
+import (
+	"regexp"
+	"sync"
+
+	"github.com/mrusme/overpush/models/message"
+)
+
+// Rule routes messages that match all of its conditions to specific targets
+// and/or destinations. Conditions that are not set always match.
+type Rule struct {
+	MinPriority *int
+	MaxPriority *int
+	// Title, Message and Device are regular expressions
+	Title   string
+	Message string
+	Device  string
+	// Fields maps names of `CustomFormat.Fields` to regular expressions
+	Fields map[string]string
+
+	// Targets replaces the application's targets; if empty, the application's
+	// targets are used
+	Targets []string
+	// TargetArgs replaces the application's TargetArgs, either per target ID or
+	// for all targets
+	TargetArgs map[string]interface{}
+	// Drop discards matching messages
+	Drop bool
+	// Continue evaluates the following rules as well, instead of stopping at
+	// this one
+	Continue bool
+}
+
+// Route is a target a message should be delivered to, with its args.
+type Route struct {
+	TargetID   string
+	TargetArgs map[string]interface{}
+	// Device is the name of the user's device the route belongs to, if any
+	Device string
+}
+
+type compiledRegexp struct {
+	re  *regexp.Regexp
+	err error
+}
+
+// regexps caches the compiled rule expressions, so that every expression is
+// only compiled once instead of for every message.
+var regexps sync.Map
+
+func compileRegexp(expr string) (*regexp.Regexp, error) {
+	if cached, ok := regexps.Load(expr); ok {
+		return cached.(compiledRegexp).re, cached.(compiledRegexp).err
+	}
+
+	re, err := regexp.Compile(expr)
+	regexps.Store(expr, compiledRegexp{re: re, err: err})
+	return re, err
+}
+
+func matchRegexp(expr string, value string) (bool, error) {
+	if expr == "" {
+		return true, nil
+	}
+
+	re, err := compileRegexp(expr)
+	if err != nil {
+		return false, err
+	}
+
+	return re.MatchString(value), nil
+}
+
+func (r *Rule) Matches(m message.Message) (bool, error) {
+	if r.MinPriority != nil && m.Priority < *r.MinPriority {
+		return false, nil
+	}
+	if r.MaxPriority != nil && m.Priority > *r.MaxPriority {
+		return false, nil
+	}
+
+	for _, cond := range [][2]string{
+		{r.Title, m.Title},
+		{r.Message, m.Message},
+		{r.Device, m.Device},
+	} {
+		if ok, err := matchRegexp(cond[0], cond[1]); !ok || err != nil {
+			return false, err
+		}
+	}
+
+	for name, expr := range r.Fields {
+		if ok, err := matchRegexp(expr, m.GetField(name)); !ok || err != nil {
+			return false, err
+		}
+	}
+
+	return true, nil
+}
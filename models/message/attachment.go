@@ -0,0 +1,80 @@
+package message
+
+import (
+	"encoding/base64"
+	"mime"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// preferredExtensions are used for MIME types that have multiple extensions
+var preferredExtensions = map[string]string{
+	"image/jpeg": ".jpg",
+	"text/plain": ".txt",
+	"text/html":  ".html",
+}
+
+// HasAttachment reports whether the message carries an attachment, either as
+// URL, as raw data or base64-encoded.
+func (msg *Message) HasAttachment() bool {
+	return msg.Attachment != "" ||
+		(msg.AttachmentBase64 != "" && msg.AttachmentType != "")
+}
+
+// GetAttachmentURL returns the attachment if it is an HTTP(S) URL.
+func (msg *Message) GetAttachmentURL() string {
+	u, err := url.Parse(msg.Attachment)
+	if err != nil || (u.Scheme != "http" && u.Scheme != "https") ||
+		u.Host == "" {
+		return ""
+	}
+	return msg.Attachment
+}
+
+// GetAttachmentData returns the attachment's data and MIME type, taken either
+// from AttachmentBase64 or, if it is not a URL, from Attachment.
+func (msg *Message) GetAttachmentData() ([]byte, string, error) {
+	var data []byte
+
+	if msg.AttachmentBase64 != "" && msg.AttachmentType != "" {
+		var err error
+		data, err = base64.StdEncoding.DecodeString(msg.AttachmentBase64)
+		if err != nil {
+			return nil, "", err
+		}
+	} else if msg.Attachment != "" && msg.GetAttachmentURL() == "" {
+		data = []byte(msg.Attachment)
+	} else {
+		return nil, "", nil
+	}
+
+	mimeType := msg.AttachmentType
+	if mimeType == "" {
+		mimeType = http.DetectContentType(data)
+	}
+
+	return data, mimeType, nil
+}
+
+// GetAttachmentFilename returns a filename for the attachment, based on its
+// MIME type.
+func (msg *Message) GetAttachmentFilename(mimeType string) string {
+	if u := msg.GetAttachmentURL(); u != "" {
+		if parsed, err := url.Parse(u); err == nil {
+			parts := strings.Split(parsed.Path, "/")
+			if name := parts[len(parts)-1]; name != "" {
+				return name
+			}
+		}
+	}
+
+	mediaType, _, _ := mime.ParseMediaType(mimeType)
+	if ext, ok := preferredExtensions[mediaType]; ok {
+		return "attachment" + ext
+	}
+	if exts, err := mime.ExtensionsByType(mediaType); err == nil && len(exts) > 0 {
+		return "attachment" + exts[0]
+	}
+	return "attachment"
+}
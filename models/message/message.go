@@ -1,6 +1,10 @@
 package message
 
-import "fmt"
+import (
+	"fmt"
+	"strings"
+	"time"
+)
 
 type Message struct {
 	Token   string `json:"token",validate:"required,printascii"`
@@ -8,7 +12,7 @@ type Message struct {
 	Message string `json:"message",validate:"required"`
 
 	Attachment       string `json:"attachment",validate:""`
-	AttachmentBase64 string `json:"attachment",validate:"base64"`
+	AttachmentBase64 string `json:"attachment_base64",validate:"base64"`
 	AttachmentType   string `json:"attachment_type",validate:""`
 	Device           string `json:"device",validate:""`
 	HTML             int    `json:"html",validate:"min=0,max=1"`
@@ -19,6 +23,16 @@ type Message struct {
 	URL              string `json:"url",validate:"http_url"`
 	URLTitle         string `json:"url_title",validate:""`
 
+	// Emergency-priority (priority=2) specific fields, see
+	// https://pushover.net/api/receipts
+	Retry    int    `json:"retry" form:"retry"`
+	Expire   int    `json:"expire" form:"expire"`
+	Callback string `json:"callback" form:"callback"`
+	Tags     string `json:"tags" form:"tags"`
+
+	// SendAt delays the delivery until the given Unix timestamp
+	SendAt int64 `json:"send_at" form:"send_at"`
+
 	// Note: These are "private" fields that should never be set via the API.
 	// Hence these fields have getters/setters, to make it obvious throughout
 	// the code. Unfortunately the fields cannot be made truly private (lowercase)
@@ -28,7 +42,17 @@ type Message struct {
 	// Important: Whenever a message is being received from outside, the
 	// ClearInternal method must be called.
 	Internal struct {
-		ViaSubmit bool `json:"via_submit",validate:"-"`
+		ViaSubmit bool   `json:"via_submit",validate:"-"`
+		Receipt   string `json:"receipt"`
+		// ReceivedAt is the Unix timestamp the API received the message at
+		ReceivedAt int64 `json:"received_at"`
+		// Digest is set on messages that combine an application's digest
+		Digest bool `json:"digest"`
+		// Fields holds the values of `CustomFormat.Fields`, for routing
+		Fields map[string]string `json:"fields"`
+		// DeliveryID identifies the delivery of the message to a target and
+		// stays the same when the delivery is retried
+		DeliveryID string `json:"delivery_id"`
 	} `json:"_internal",validate:"-"`
 }
 
@@ -62,6 +86,11 @@ func (msg *Message) ToString() string {
 
 func (msg *Message) ClearInternal() {
 	msg.Internal.ViaSubmit = false
+	msg.Internal.Receipt = ""
+	msg.Internal.ReceivedAt = 0
+	msg.Internal.Digest = false
+	msg.Internal.Fields = nil
+	msg.Internal.DeliveryID = ""
 }
 
 func (msg *Message) SetViaSubmit(submit bool) {
@@ -72,3 +101,133 @@ func (msg *Message) IsViaSubmit() bool {
 	return msg.Internal.ViaSubmit
 }
 
+func (msg *Message) SetDeliveryID(deliveryID string) {
+	msg.Internal.DeliveryID = deliveryID
+}
+
+// GetDeliveryID returns the ID of the delivery to the target, which targets
+// can use to have retries deduplicated (e.g. Matrix transaction IDs). It is
+// empty for messages that are not processed by asynq.
+func (msg *Message) GetDeliveryID() string {
+	return msg.Internal.DeliveryID
+}
+
+func (msg *Message) SetReceivedAt(receivedAt int64) {
+	msg.Internal.ReceivedAt = receivedAt
+}
+
+func (msg *Message) GetReceivedAt() int64 {
+	return msg.Internal.ReceivedAt
+}
+
+func (msg *Message) SetDigest(digest bool) {
+	msg.Internal.Digest = digest
+}
+
+func (msg *Message) IsDigest() bool {
+	return msg.Internal.Digest
+}
+
+func (msg *Message) SetReceipt(receipt string) {
+	msg.Internal.Receipt = receipt
+}
+
+func (msg *Message) GetReceipt() string {
+	return msg.Internal.Receipt
+}
+
+func (msg *Message) IsEmergency() bool {
+	return msg.Priority == 2
+}
+
+func (msg *Message) GetTags() []string {
+	var tags []string
+
+	for _, tag := range strings.Split(msg.Tags, ",") {
+		tag = strings.TrimSpace(tag)
+		if tag != "" {
+			tags = append(tags, tag)
+		}
+	}
+
+	return tags
+}
+
+// SetField sets the value extracted via `CustomFormat.Fields`. Field names
+// are case-insensitive, as Viper lower-cases all keys when reading the config.
+func (msg *Message) SetField(name string, value string) {
+	if msg.Internal.Fields == nil {
+		msg.Internal.Fields = make(map[string]string)
+	}
+	msg.Internal.Fields[strings.ToLower(name)] = value
+}
+
+func (msg *Message) GetField(name string) string {
+	return msg.Internal.Fields[strings.ToLower(name)]
+}
+
+// GetSendAt returns the Unix timestamp the message should be delivered at,
+// which is either SendAt or, if byTimestamp is set, the message Timestamp.
+func (msg *Message) GetSendAt(byTimestamp bool) int64 {
+	if msg.SendAt > 0 {
+		return msg.SendAt
+	}
+	if byTimestamp == true {
+		return msg.Timestamp
+	}
+	return 0
+}
+
+// GetExpiresAt returns the Unix timestamp after which the message must not be
+// delivered anymore, or 0 if the message does not expire. The TTL counts from
+// the message timestamp (or the time it was received, if it has none) or,
+// for scheduled messages, from the time it is being sent at. Emergency-priority
+// messages do not expire, as they are handled by their receipt's expire.
+func (msg *Message) GetExpiresAt() int64 {
+	if msg.TTL <= 0 || msg.IsEmergency() == true {
+		return 0
+	}
+
+	var from int64 = msg.Timestamp
+	if from == 0 {
+		from = msg.GetReceivedAt()
+	}
+	if msg.SendAt > from {
+		from = msg.SendAt
+	}
+	if from == 0 {
+		return 0
+	}
+
+	return from + int64(msg.TTL)
+}
+
+func (msg *Message) IsExpired() bool {
+	expiresAt := msg.GetExpiresAt()
+	return expiresAt > 0 && time.Now().Unix() >= expiresAt
+}
+
+// GetPriorityColor returns an RGB color for the message's priority, for
+// targets that can highlight messages, ranging from gray for the lowest to red
+// for emergency priority.
+func (msg *Message) GetPriorityColor() int {
+	switch {
+	case msg.Priority <= -2:
+		return 0x95a5a6
+	case msg.Priority == -1:
+		return 0x3498db
+	case msg.Priority == 1:
+		return 0xe67e22
+	case msg.Priority >= 2:
+		return 0xe74c3c
+	}
+	return 0x2ecc71
+}
+
+// GetTime returns the message timestamp, or the current time if it has none.
+func (msg *Message) GetTime() time.Time {
+	if msg.Timestamp > 0 {
+		return time.Unix(msg.Timestamp, 0)
+	}
+	return time.Now()
+}
@@ -0,0 +1,8 @@
+package message
+
+// Scheduled is a message that is waiting to be delivered at SendAt.
+type Scheduled struct {
+	ID      string  `json:"id"`
+	SendAt  int64   `json:"send_at"`
+	Message Message `json:"message"`
+}
@@ -0,0 +1,180 @@
+package quiethours
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+const (
+	// DEFAULT_BYPASS_PRIORITY is the priority from which on messages are
+	// delivered during quiet hours, unless configured otherwise
+	DEFAULT_BYPASS_PRIORITY = 1
+
+	// maxChained limits how many consecutive windows are being followed when
+	// computing the end of the quiet hours
+	maxChained = 16
+)
+
+// Window is a period of quiet hours during which messages below
+// BypassPriority are held until the window ends, or dropped if Drop is set.
+// Start and End are "HH:MM" in the window's Timezone; a window that ends
+// before it starts spans midnight. Days limits the window to the weekdays it
+// starts on (e.g. "mon", "Tuesday"), with no days meaning every day.
+type Window struct {
+	Timezone       string
+	Days           []string
+	Start          string
+	End            string
+	BypassPriority *int
+	Drop           bool
+}
+
+// Bypasses reports whether a message with the given priority is delivered
+// despite the window. Emergency-priority messages always bypass quiet hours.
+func (w *Window) Bypasses(priority int) bool {
+	if priority >= 2 {
+		return true
+	}
+
+	bypass := DEFAULT_BYPASS_PRIORITY
+	if w.BypassPriority != nil {
+		bypass = *w.BypassPriority
+	}
+
+	return priority >= bypass
+}
+
+// ActiveUntil returns the end of the window if `now` lies within it.
+func (w *Window) ActiveUntil(now time.Time) (time.Time, bool, error) {
+	loc := time.UTC
+	if w.Timezone != "" {
+		var err error
+		if loc, err = time.LoadLocation(w.Timezone); err != nil {
+			return time.Time{}, false, err
+		}
+	}
+
+	start, err := parseClock(w.Start)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	end, err := parseClock(w.End)
+	if err != nil {
+		return time.Time{}, false, err
+	}
+	if start == end {
+		return time.Time{}, false, errors.New("Quiet hours start and end are equal")
+	}
+
+	now = now.In(loc)
+	// A window spanning midnight might have started the day before
+	for _, offset := range []int{0, -1} {
+		day := now.Day() + offset
+
+		on, err := w.isOn(
+			time.Date(now.Year(), now.Month(), day, 0, 0, 0, 0, loc).Weekday())
+		if err != nil {
+			return time.Time{}, false, err
+		}
+		if on == false {
+			continue
+		}
+
+		from := time.Date(now.Year(), now.Month(), day,
+			start/60, start%60, 0, 0, loc)
+		if end < start {
+			day++
+		}
+		until := time.Date(now.Year(), now.Month(), day,
+			end/60, end%60, 0, 0, loc)
+
+		if now.Before(from) == false && now.Before(until) == true {
+			return until, true, nil
+		}
+	}
+
+	return time.Time{}, false, nil
+}
+
+func (w *Window) isOn(weekday time.Weekday) (bool, error) {
+	if len(w.Days) == 0 {
+		return true, nil
+	}
+
+	for _, day := range w.Days {
+		day = strings.ToLower(strings.TrimSpace(day))
+		if len(day) < 3 {
+			return false, fmt.Errorf("Invalid quiet hours day: %s", day)
+		}
+
+		found := false
+		for wd := time.Sunday; wd <= time.Saturday; wd++ {
+			name := strings.ToLower(wd.String())
+			if strings.HasPrefix(name, day) {
+				found = true
+				if wd == weekday {
+					return true, nil
+				}
+			}
+		}
+		if found == false {
+			return false, fmt.Errorf("Invalid quiet hours day: %s", day)
+		}
+	}
+
+	return false, nil
+}
+
+// parseClock returns the minutes since midnight of a "HH:MM" time.
+func parseClock(s string) (int, error) {
+	t, err := time.Parse("15:04", strings.TrimSpace(s))
+	if err != nil {
+		return 0, fmt.Errorf("Invalid quiet hours time: %s", s)
+	}
+
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// Until returns the time the quiet hours that a message with the given
+// priority is subject to end, following windows that start right when
+// another one ends. It reports whether the message should be dropped instead,
+// which is the case if any of the active windows drops messages.
+func Until(
+	windows []Window,
+	now time.Time,
+	priority int,
+) (until time.Time, drop bool, active bool, err error) {
+	until = now
+	for i := 0; i < maxChained; i++ {
+		extended := false
+		for _, w := range windows {
+			if w.Bypasses(priority) == true {
+				continue
+			}
+
+			end, ok, err := w.ActiveUntil(until)
+			if err != nil {
+				return time.Time{}, false, false, err
+			}
+			if ok == false {
+				continue
+			}
+
+			active = true
+			if w.Drop == true {
+				return end, true, true, nil
+			}
+			if end.After(until) {
+				until = end
+				extended = true
+			}
+		}
+		if extended == false {
+			break
+		}
+	}
+
+	return until, false, active, nil
+}
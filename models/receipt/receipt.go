@@ -0,0 +1,67 @@
+package receipt
+
+import (
+	"crypto/rand"
+	"math/big"
+	"time"
+)
+
+const (
+	idAlphabet = "abcdefghijklmnopqrstuvwxyzABCDEFGHIJKLMNOPQRSTUVWXYZ0123456789"
+	idLength   = 30
+
+	MinRetry  = 30
+	MaxExpire = 10800
+)
+
+type Receipt struct {
+	ID       string   `json:"receipt"`
+	User     string   `json:"user"`
+	Token    string   `json:"token"`
+	Retry    int      `json:"retry"`
+	Expire   int      `json:"expire"`
+	Callback string   `json:"callback"`
+	Tags     []string `json:"tags"`
+
+	Deliveries      int   `json:"deliveries"`
+	CreatedAt       int64 `json:"created_at"`
+	ExpiresAt       int64 `json:"expires_at"`
+	LastDeliveredAt int64 `json:"last_delivered_at"`
+
+	Acknowledged         bool   `json:"acknowledged"`
+	AcknowledgedAt       int64  `json:"acknowledged_at"`
+	AcknowledgedBy       string `json:"acknowledged_by"`
+	AcknowledgedByDevice string `json:"acknowledged_by_device"`
+
+	CalledBack   bool  `json:"called_back"`
+	CalledBackAt int64 `json:"called_back_at"`
+
+	Cancelled bool `json:"cancelled"`
+}
+
+func NewID() (string, error) {
+	id := make([]byte, idLength)
+	max := big.NewInt(int64(len(idAlphabet)))
+
+	for i := range id {
+		n, err := rand.Int(rand.Reader, max)
+		if err != nil {
+			return "", err
+		}
+		id[i] = idAlphabet[n.Int64()]
+	}
+
+	return string(id), nil
+}
+
+func (r *Receipt) IsExpired() bool {
+	return time.Now().Unix() >= r.ExpiresAt
+}
+
+// IsActive reports whether the emergency message belonging to this receipt
+// still needs to be redelivered.
+func (r *Receipt) IsActive() bool {
+	return r.Acknowledged == false &&
+		r.Cancelled == false &&
+		r.IsExpired() == false
+}
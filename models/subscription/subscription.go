@@ -0,0 +1,65 @@
+package subscription
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// Subscription is a Web Push subscription of a browser, as returned by the
+// browser's `PushSubscription.toJSON()`, which a user registered for a
+// `webpush` target.
+type Subscription struct {
+	ID        string `json:"id"`
+	TargetID  string `json:"target_id"`
+	Name      string `json:"name"`
+	Endpoint  string `json:"endpoint"`
+	P256dh    string `json:"p256dh"`
+	Auth      string `json:"auth"`
+	CreatedAt int64  `json:"created_at"`
+}
+
+// GetID returns the ID for the subscription's endpoint, so that registering the
+// same subscription again updates it instead of adding a duplicate.
+func GetID(endpoint string) string {
+	sum := sha256.Sum256([]byte(endpoint))
+	return hex.EncodeToString(sum[:8])
+}
+
+func decodeKey(key string) ([]byte, error) {
+	key = strings.TrimRight(key, "=")
+	if strings.ContainsAny(key, "+/") {
+		return base64.RawStdEncoding.DecodeString(key)
+	}
+	return base64.RawURLEncoding.DecodeString(key)
+}
+
+// GetKeys returns the decoded P-256 public key and the authentication secret
+// of the subscription.
+func (s *Subscription) GetKeys() ([]byte, []byte, error) {
+	p256dh, err := decodeKey(s.P256dh)
+	if err != nil || len(p256dh) != 65 {
+		return nil, nil, errors.New("Invalid subscription key p256dh")
+	}
+
+	auth, err := decodeKey(s.Auth)
+	if err != nil || len(auth) != 16 {
+		return nil, nil, errors.New("Invalid subscription key auth")
+	}
+
+	return p256dh, auth, nil
+}
+
+// Validate checks the endpoint and the keys of the subscription.
+func (s *Subscription) Validate() error {
+	u, err := url.Parse(s.Endpoint)
+	if err != nil || u.Scheme != "https" || u.Host == "" {
+		return errors.New("Subscription endpoint must be an HTTPS URL")
+	}
+
+	_, _, err = s.GetKeys()
+	return err
+}
@@ -0,0 +1,61 @@
+package target
+
+import (
+	"errors"
+	"time"
+)
+
+// RetryAfterError is returned by targets whose service asked to retry the
+// delivery after a specific time, e.g. when being rate limited.
+type RetryAfterError struct {
+	After time.Duration
+	Err   error
+}
+
+func (e *RetryAfterError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *RetryAfterError) Unwrap() error {
+	return e.Err
+}
+
+// RetryAfter wraps the error, so that the delivery is retried after the
+// given time instead of using the target's backoff.
+func RetryAfter(err error, after time.Duration) error {
+	return &RetryAfterError{After: after, Err: err}
+}
+
+// GetRetryAfter returns the time after which the delivery should be retried,
+// if the error asks for it.
+func GetRetryAfter(err error) (time.Duration, bool) {
+	var ra *RetryAfterError
+	if errors.As(err, &ra) {
+		return ra.After, true
+	}
+	return 0, false
+}
+
+// PermanentError is returned by targets for deliveries that cannot succeed
+// by retrying them, e.g. because the recipient does not exist.
+type PermanentError struct {
+	Err error
+}
+
+func (e *PermanentError) Error() string {
+	return e.Err.Error()
+}
+
+func (e *PermanentError) Unwrap() error {
+	return e.Err
+}
+
+// Permanent wraps the error, so that the delivery is not retried.
+func Permanent(err error) error {
+	return &PermanentError{Err: err}
+}
+
+func IsPermanent(err error) bool {
+	var pe *PermanentError
+	return errors.As(err, &pe)
+}
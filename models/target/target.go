@@ -1,8 +1,61 @@
 package target
 
+import "time"
+
+const (
+	DEFAULT_MAX_RETRY = 5
+	DEFAULT_TIMEOUT   = 30 * time.Minute
+)
+
 type Target struct {
 	Enable bool
 	ID     string
 	Type   string
 	Args   map[string]interface{}
+
+	// MaxRetry is the number of times a delivery to this target is retried
+	// before it is archived; 0 uses the default, negative values disable retries
+	MaxRetry int
+	// Timeout is the time in seconds a single delivery attempt may take
+	Timeout int
+	// RetryBackoff is the delay in seconds before the first retry, which doubles
+	// with every further retry up to RetryBackoffMax seconds; 0 uses asynq's
+	// default exponential backoff
+	RetryBackoff    int
+	RetryBackoffMax int
+}
+
+func (t *Target) GetMaxRetry() int {
+	if t.MaxRetry < 0 {
+		return 0
+	} else if t.MaxRetry == 0 {
+		return DEFAULT_MAX_RETRY
+	}
+	return t.MaxRetry
+}
+
+func (t *Target) GetTimeout() time.Duration {
+	if t.Timeout <= 0 {
+		return DEFAULT_TIMEOUT
+	}
+	return time.Duration(t.Timeout) * time.Second
+}
+
+// GetRetryDelay returns the delay before the n-th retry and false, if the
+// target does not specify a backoff.
+func (t *Target) GetRetryDelay(n int) (time.Duration, bool) {
+	if t.RetryBackoff <= 0 {
+		return 0, false
+	}
+
+	delay := time.Duration(t.RetryBackoff) * time.Second
+	max := time.Duration(t.RetryBackoffMax) * time.Second
+	for i := 0; i < n; i++ {
+		delay *= 2
+		if max > 0 && delay >= max {
+			return max, true
+		}
+	}
+
+	return delay, true
 }
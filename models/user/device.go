@@ -0,0 +1,44 @@
+package user
+
+import "strings"
+
+// Device is a named destination of a user, e.g. "phone" for an XMPP account,
+// that Pushover clients can address using the `device` field.
+type Device struct {
+	Name       string
+	Target     string
+	TargetArgs map[string]interface{}
+}
+
+// GetDevices returns the devices for the comma-separated list of device names,
+// as well as the names that do not match any device. An empty list returns all
+// devices.
+func (usr *User) GetDevices(names string) ([]Device, []string) {
+	var devices []Device
+	var unknown []string
+
+	if strings.TrimSpace(names) == "" {
+		return usr.Devices, unknown
+	}
+
+	for _, name := range strings.Split(names, ",") {
+		name = strings.TrimSpace(name)
+		if name == "" {
+			continue
+		}
+
+		found := false
+		for _, device := range usr.Devices {
+			if strings.EqualFold(device.Name, name) {
+				devices = append(devices, device)
+				found = true
+				break
+			}
+		}
+		if !found {
+			unknown = append(unknown, name)
+		}
+	}
+
+	return devices, unknown
+}
@@ -2,10 +2,13 @@ package user
 
 import (
 	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/quiethours"
 )
 
 type User struct {
 	Enable       bool
 	Key          string
 	Applications []application.Application
+	Devices      []Device
+	QuietHours   []quiethours.Window
 }
@@ -0,0 +1,58 @@
+package dedup
+
+import (
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/store"
+)
+
+type Repository struct {
+	cfg *config.Config
+	st  *store.Store
+}
+
+func New(cfg *config.Config, st *store.Store) (*Repository, error) {
+	repo := new(Repository)
+	repo.cfg = cfg
+	repo.st = st
+
+	return repo, nil
+}
+
+// IsDuplicate reports whether the message is a duplicate according to the
+// application's dedup configuration, using the rendered dedup key. For
+// messages that are not duplicates, it returns the number of duplicates that
+// were suppressed since the message was last delivered.
+func (repo *Repository) IsDuplicate(
+	dd application.Dedup,
+	key string,
+	m message.Message,
+) (bool, int64, error) {
+	if dd.IsEnabled() == false {
+		return false, 0, nil
+	}
+
+	return repo.st.Dedup(
+		m.Token,
+		dd.GetHash(key, m),
+		time.Duration(dd.Window)*time.Second,
+	)
+}
+
+// Release forgets the message that IsDuplicate remembered, so that it is not
+// considered a duplicate when its request is being retried.
+func (repo *Repository) Release(
+	dd application.Dedup,
+	key string,
+	m message.Message,
+	repeated int64,
+) error {
+	if dd.IsEnabled() == false {
+		return nil
+	}
+
+	return repo.st.ReleaseDedup(m.Token, dd.GetHash(key, m), repeated)
+}
@@ -0,0 +1,93 @@
+package digest
+
+import (
+	"encoding/json"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/store"
+)
+
+type Repository struct {
+	cfg *config.Config
+	st  *store.Store
+}
+
+func New(cfg *config.Config, st *store.Store) (*Repository, error) {
+	repo := new(Repository)
+	repo.cfg = cfg
+	repo.st = st
+
+	return repo, nil
+}
+
+// AddToDigest buffers the message until the application's digest is due.
+func (repo *Repository) AddToDigest(m message.Message) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return repo.st.AddDigest(m.Token, data)
+}
+
+// GetDigest returns the buffered messages of the application. Messages that
+// cannot be decoded are skipped, but still count towards the number of
+// messages to remove using RemoveFromDigest.
+func (repo *Repository) GetDigest(token string) ([]message.Message, int, error) {
+	data, err := repo.st.GetDigest(token)
+	if err != nil {
+		return []message.Message{}, 0, err
+	}
+
+	return decode(data), len(data), nil
+}
+
+// RemoveFromDigest removes the `n` oldest buffered messages of the
+// application, once they were delivered.
+func (repo *Repository) RemoveFromDigest(token string, n int) error {
+	return repo.st.RemoveDigest(token, n)
+}
+
+// AddToHeld buffers the message until the quiet hours end at `until`.
+func (repo *Repository) AddToHeld(m message.Message, until int64) error {
+	data, err := json.Marshal(m)
+	if err != nil {
+		return err
+	}
+
+	return repo.st.AddHeld(m.Token, until, data)
+}
+
+// GetHeld returns the messages the application holds back until `until`,
+// just like GetDigest.
+func (repo *Repository) GetHeld(
+	token string,
+	until int64,
+) ([]message.Message, int, error) {
+	data, err := repo.st.GetHeld(token, until)
+	if err != nil {
+		return []message.Message{}, 0, err
+	}
+
+	return decode(data), len(data), nil
+}
+
+// RemoveFromHeld removes the `n` oldest messages the application holds back
+// until `until`, once they were delivered.
+func (repo *Repository) RemoveFromHeld(token string, until int64, n int) error {
+	return repo.st.RemoveHeld(token, until, n)
+}
+
+func decode(data [][]byte) []message.Message {
+	msgs := make([]message.Message, 0, len(data))
+	for _, item := range data {
+		var m message.Message
+		if err := json.Unmarshal(item, &m); err != nil {
+			continue
+		}
+		msgs = append(msgs, m)
+	}
+
+	return msgs
+}
@@ -0,0 +1,53 @@
+package idempotency
+
+import (
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/store"
+)
+
+type Repository struct {
+	cfg *config.Config
+	st  *store.Store
+}
+
+func New(cfg *config.Config, st *store.Store) (*Repository, error) {
+	repo := new(Repository)
+	repo.cfg = cfg
+	repo.st = st
+
+	return repo, nil
+}
+
+// Claim claims the idempotency key for the request and returns the ID of the
+// request that claimed it first, which is the given request ID unless the
+// key was already used within the retention window.
+func (repo *Repository) Claim(
+	token string,
+	idempotencyKey string,
+	requestID string,
+) (string, error) {
+	return repo.st.ClaimIdempotencyKey(token, idempotencyKey, requestID)
+}
+
+// SetResponse remembers the response to the request that claimed the
+// idempotency key.
+func (repo *Repository) SetResponse(
+	token string,
+	idempotencyKey string,
+	response []byte,
+) error {
+	return repo.st.SetIdempotencyResponse(token, idempotencyKey, response)
+}
+
+// GetResponse returns the response to the request that claimed the
+// idempotency key, or an empty response if it is still being processed.
+func (repo *Repository) GetResponse(
+	token string,
+	idempotencyKey string,
+) ([]byte, error) {
+	return repo.st.GetIdempotencyResponse(token, idempotencyKey)
+}
+
+func (repo *Repository) Release(token string, idempotencyKey string) error {
+	return repo.st.ReleaseIdempotencyKey(token, idempotencyKey)
+}
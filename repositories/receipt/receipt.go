@@ -0,0 +1,189 @@
+package receipt
+
+import (
+	"errors"
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/receipt"
+	"github.com/mrusme/overpush/store"
+)
+
+const (
+	// MAX_PENDING limits the number of receipts remembered per destination
+	MAX_PENDING = 64
+)
+
+type Repository struct {
+	cfg *config.Config
+	st  *store.Store
+}
+
+func New(cfg *config.Config, st *store.Store) (*Repository, error) {
+	repo := new(Repository)
+	repo.cfg = cfg
+	repo.st = st
+
+	return repo, nil
+}
+
+// CreateReceipt creates the receipt for an emergency message, which expires
+// `Expire` seconds after the message's first delivery at `sendAt` (or now).
+func (repo *Repository) CreateReceipt(
+	m message.Message,
+	sendAt int64,
+) (receipt.Receipt, error) {
+	id, err := receipt.NewID()
+	if err != nil {
+		return receipt.Receipt{}, err
+	}
+
+	now := time.Now()
+	if sendAt > now.Unix() {
+		now = time.Unix(sendAt, 0)
+	}
+	rcpt := receipt.Receipt{
+		ID:        id,
+		User:      m.User,
+		Token:     m.Token,
+		Retry:     m.Retry,
+		Expire:    m.Expire,
+		Callback:  m.Callback,
+		Tags:      m.GetTags(),
+		CreatedAt: now.Unix(),
+		ExpiresAt: now.Add(time.Duration(m.Expire) * time.Second).Unix(),
+	}
+
+	if err := repo.st.SaveReceipt(rcpt); err != nil {
+		return receipt.Receipt{}, err
+	}
+
+	return rcpt, nil
+}
+
+func (repo *Repository) GetReceipt(id string) (receipt.Receipt, error) {
+	return repo.st.GetReceipt(id)
+}
+
+func (repo *Repository) GetReceiptForToken(
+	id string,
+	token string,
+) (receipt.Receipt, error) {
+	rcpt, err := repo.st.GetReceipt(id)
+	if err != nil {
+		return receipt.Receipt{}, err
+	}
+
+	if rcpt.Token != token {
+		return receipt.Receipt{}, errors.New("Receipt not found")
+	}
+
+	return rcpt, nil
+}
+
+func (repo *Repository) SaveReceipt(rcpt receipt.Receipt) error {
+	return repo.st.SaveReceipt(rcpt)
+}
+
+func (repo *Repository) MarkDelivered(id string) (receipt.Receipt, error) {
+	return repo.st.UpdateReceipt(id, func(rcpt *receipt.Receipt) error {
+		rcpt.Deliveries++
+		rcpt.LastDeliveredAt = time.Now().Unix()
+		return nil
+	})
+}
+
+// AcknowledgeReceipt marks the receipt as acknowledged by the given device.
+// It fails if the receipt is not active anymore.
+func (repo *Repository) AcknowledgeReceipt(
+	id string,
+	device string,
+) (receipt.Receipt, error) {
+	return repo.st.UpdateReceipt(id, func(rcpt *receipt.Receipt) error {
+		if rcpt.IsActive() == false {
+			return errors.New("Receipt not active")
+		}
+
+		rcpt.Acknowledged = true
+		rcpt.AcknowledgedAt = time.Now().Unix()
+		rcpt.AcknowledgedBy = rcpt.User
+		rcpt.AcknowledgedByDevice = device
+		return nil
+	})
+}
+
+func (repo *Repository) MarkCalledBack(id string) error {
+	_, err := repo.st.UpdateReceipt(id, func(rcpt *receipt.Receipt) error {
+		rcpt.CalledBack = true
+		rcpt.CalledBackAt = time.Now().Unix()
+		return nil
+	})
+	return err
+}
+
+func (repo *Repository) CancelReceipt(id string, token string) error {
+	_, err := repo.st.UpdateReceipt(id, func(rcpt *receipt.Receipt) error {
+		if rcpt.Token != token {
+			return errors.New("Receipt not found")
+		}
+
+		rcpt.Cancelled = true
+		return nil
+	})
+	return err
+}
+
+// AddPendingReceipt remembers the receipt as acknowledgeable by replies of the
+// destination, until the longest possible expiry of emergency messages.
+func (repo *Repository) AddPendingReceipt(
+	destination string,
+	receiptID string,
+) error {
+	return repo.st.AddPendingReceipt(destination, receiptID, MAX_PENDING,
+		time.Duration(receipt.MaxExpire)*time.Second)
+}
+
+func (repo *Repository) GetPendingReceipts(destination string) ([]string, error) {
+	return repo.st.GetPendingReceipts(destination)
+}
+
+func (repo *Repository) RemovePendingReceipt(
+	destination string,
+	receiptID string,
+) (bool, error) {
+	return repo.st.RemovePendingReceipt(destination, receiptID)
+}
+
+func (repo *Repository) CancelReceiptsByTag(
+	token string,
+	tag string,
+) (int, error) {
+	var cancelled int = 0
+
+	ids, err := repo.st.GetReceiptIDsByTag(token, tag)
+	if err != nil {
+		return 0, err
+	}
+
+	for _, id := range ids {
+		var active bool = false
+		if _, err := repo.st.UpdateReceipt(id, func(rcpt *receipt.Receipt) error {
+			if rcpt.Token != token {
+				return errors.New("Receipt not found")
+			}
+			if active = rcpt.IsActive(); active == true {
+				rcpt.Cancelled = true
+			}
+			return nil
+		}); err != nil {
+			// The receipt might have been removed in the meantime
+			continue
+		}
+		if active == true {
+			cancelled++
+		}
+	}
+
+	return cancelled, nil
+}
@@ -4,27 +4,43 @@ import (
 	"github.com/mrusme/overpush/config"
 	"github.com/mrusme/overpush/database"
 	"github.com/mrusme/overpush/repositories/application"
+	"github.com/mrusme/overpush/repositories/dedup"
+	"github.com/mrusme/overpush/repositories/digest"
+	"github.com/mrusme/overpush/repositories/idempotency"
+	"github.com/mrusme/overpush/repositories/receipt"
+	"github.com/mrusme/overpush/repositories/scheduled"
+	"github.com/mrusme/overpush/repositories/subscription"
 	"github.com/mrusme/overpush/repositories/target"
 	"github.com/mrusme/overpush/repositories/user"
+	"github.com/mrusme/overpush/store"
 )
 
 type Repositories struct {
-	cfg         *config.Config
-	db          *database.Database
-	User        *user.Repository
-	Application *application.Repository
-	Target      *target.Repository
+	cfg          *config.Config
+	db           *database.Database
+	st           *store.Store
+	User         *user.Repository
+	Application  *application.Repository
+	Target       *target.Repository
+	Receipt      *receipt.Repository
+	Scheduled    *scheduled.Repository
+	Digest       *digest.Repository
+	Dedup        *dedup.Repository
+	Idempotency  *idempotency.Repository
+	Subscription *subscription.Repository
 }
 
 func New(
 	cfg *config.Config,
 	db *database.Database,
+	st *store.Store,
 ) (*Repositories, error) {
 	var repos *Repositories = new(Repositories)
 	var err error
 
 	repos.cfg = cfg
 	repos.db = db
+	repos.st = st
 
 	var userRepo *user.Repository
 	if userRepo, err = user.New(cfg, db); err != nil {
@@ -41,13 +57,53 @@ func New(
 		return nil, err
 	}
 
+	var receiptRepo *receipt.Repository
+	if receiptRepo, err = receipt.New(cfg, st); err != nil {
+		return nil, err
+	}
+
+	var scheduledRepo *scheduled.Repository
+	if scheduledRepo, err = scheduled.New(cfg, st); err != nil {
+		return nil, err
+	}
+
+	var digestRepo *digest.Repository
+	if digestRepo, err = digest.New(cfg, st); err != nil {
+		return nil, err
+	}
+
+	var dedupRepo *dedup.Repository
+	if dedupRepo, err = dedup.New(cfg, st); err != nil {
+		return nil, err
+	}
+
+	var idempotencyRepo *idempotency.Repository
+	if idempotencyRepo, err = idempotency.New(cfg, st); err != nil {
+		return nil, err
+	}
+
+	var subscriptionRepo *subscription.Repository
+	if subscriptionRepo, err = subscription.New(cfg, db, st); err != nil {
+		return nil, err
+	}
+
 	repos.User = userRepo
 	repos.Application = appRepo
 	repos.Target = targetRepo
+	repos.Receipt = receiptRepo
+	repos.Scheduled = scheduledRepo
+	repos.Digest = digestRepo
+	repos.Dedup = dedupRepo
+	repos.Idempotency = idempotencyRepo
+	repos.Subscription = subscriptionRepo
 
 	return repos, nil
 }
 
 func (repos *Repositories) Shutdown() error {
+	if err := repos.st.Shutdown(); err != nil {
+		repos.db.Shutdown()
+		return err
+	}
 	return repos.db.Shutdown()
 }
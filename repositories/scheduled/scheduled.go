@@ -0,0 +1,112 @@
+package scheduled
+
+import (
+	"encoding/json"
+	"errors"
+
+	"github.com/hibiken/asynq"
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/store"
+)
+
+type Repository struct {
+	cfg       *config.Config
+	st        *store.Store
+	inspector *asynq.Inspector
+}
+
+func New(cfg *config.Config, st *store.Store) (*Repository, error) {
+	repo := new(Repository)
+	repo.cfg = cfg
+	repo.st = st
+
+	if st.Enabled() == true {
+		repo.inspector = asynq.NewInspectorFromRedisClient(st.Client())
+	}
+
+	return repo, nil
+}
+
+// AddScheduled remembers the scheduled message task for the application, so
+// that it can be listed and cancelled.
+func (repo *Repository) AddScheduled(
+	token string,
+	id string,
+	sendAt int64,
+) error {
+	return repo.st.AddScheduled(token, id, sendAt)
+}
+
+// GetScheduled returns all messages of the application that are still waiting
+// to be delivered. Entries whose tasks were processed or removed in the
+// meantime are cleaned up along the way.
+func (repo *Repository) GetScheduled(token string) ([]message.Scheduled, error) {
+	scheduled := []message.Scheduled{}
+
+	if repo.inspector == nil {
+		return scheduled, nil
+	}
+
+	entries, err := repo.st.GetScheduled(token)
+	if err != nil {
+		return scheduled, err
+	}
+
+	for _, entry := range entries {
+		info, err := repo.inspector.GetTaskInfo(store.QUEUE, entry.ID)
+		if err != nil || info.State != asynq.TaskStateScheduled {
+			if err == nil ||
+				errors.Is(err, asynq.ErrTaskNotFound) ||
+				errors.Is(err, asynq.ErrQueueNotFound) {
+				repo.st.RemoveScheduled(token, entry.ID)
+				continue
+			}
+			return scheduled, err
+		}
+
+		var m message.Message
+		if err := json.Unmarshal(info.Payload, &m); err != nil {
+			continue
+		}
+
+		scheduled = append(scheduled, message.Scheduled{
+			ID:      entry.ID,
+			SendAt:  entry.SendAt,
+			Message: m,
+		})
+	}
+
+	return scheduled, nil
+}
+
+// CancelScheduled removes the scheduled message task, if it belongs to the
+// application and has not been processed yet.
+func (repo *Repository) CancelScheduled(token string, id string) error {
+	if repo.inspector == nil {
+		return errors.New("Scheduled message not found")
+	}
+
+	entries, err := repo.st.GetScheduled(token)
+	if err != nil {
+		return err
+	}
+
+	for _, entry := range entries {
+		if entry.ID != id {
+			continue
+		}
+
+		if err := repo.inspector.DeleteTask(store.QUEUE, id); err != nil {
+			if errors.Is(err, asynq.ErrTaskNotFound) {
+				repo.st.RemoveScheduled(token, id)
+				return errors.New("Scheduled message not found")
+			}
+			return err
+		}
+
+		return repo.st.RemoveScheduled(token, id)
+	}
+
+	return errors.New("Scheduled message not found")
+}
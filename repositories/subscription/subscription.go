@@ -0,0 +1,97 @@
+package subscription
+
+import (
+	"encoding/json"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/database"
+	"github.com/mrusme/overpush/models/subscription"
+	"github.com/mrusme/overpush/store"
+)
+
+type Repository struct {
+	cfg *config.Config
+	db  *database.Database
+	st  *store.Store
+}
+
+// New creates the repository for push subscriptions, which are stored in the
+// database if it is enabled and in the store otherwise, as subscriptions are
+// registered at runtime and hence cannot be kept in the configuration.
+func New(
+	cfg *config.Config,
+	db *database.Database,
+	st *store.Store,
+) (*Repository, error) {
+	repo := new(Repository)
+	repo.cfg = cfg
+	repo.db = db
+	repo.st = st
+
+	return repo, nil
+}
+
+// GetSubscriptions returns the user's subscriptions for the target.
+func (repo *Repository) GetSubscriptions(
+	userKey string,
+	targetID string,
+) ([]subscription.Subscription, error) {
+	var all []subscription.Subscription
+
+	if repo.cfg.Database.Enable == true {
+		var err error
+		if all, err = repo.db.GetSubscriptions(userKey); err != nil {
+			return []subscription.Subscription{}, err
+		}
+	} else {
+		data, err := repo.st.GetSubscriptions(userKey)
+		if err != nil {
+			return []subscription.Subscription{}, err
+		}
+		for _, item := range data {
+			var s subscription.Subscription
+			if err := json.Unmarshal(item, &s); err != nil {
+				continue
+			}
+			all = append(all, s)
+		}
+	}
+
+	subscriptions := []subscription.Subscription{}
+	for _, s := range all {
+		if targetID == "" || s.TargetID == targetID {
+			subscriptions = append(subscriptions, s)
+		}
+	}
+
+	return subscriptions, nil
+}
+
+// SaveSubscription adds or updates the user's subscription.
+func (repo *Repository) SaveSubscription(
+	userKey string,
+	s subscription.Subscription,
+) error {
+	if repo.cfg.Database.Enable == true {
+		return repo.db.SaveSubscription(userKey, s)
+	}
+
+	data, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	return repo.st.SetSubscription(userKey, s.ID, data)
+}
+
+// RemoveSubscription removes the user's subscription and reports whether it
+// existed.
+func (repo *Repository) RemoveSubscription(
+	userKey string,
+	id string,
+) (bool, error) {
+	if repo.cfg.Database.Enable == true {
+		return repo.db.RemoveSubscription(userKey, id)
+	}
+
+	return repo.st.RemoveSubscription(userKey, id)
+}
@@ -26,3 +26,11 @@ func (repo *Repository) GetUserFromToken(token string) (user.User, error) {
 		return repo.cfg.GetUserFromToken(token)
 	}
 }
+
+func (repo *Repository) GetUserFromKey(key string) (user.User, error) {
+	if repo.cfg.Database.Enable == true {
+		return repo.db.GetUserFromKey(key)
+	} else {
+		return repo.cfg.GetUserFromKey(key)
+	}
+}
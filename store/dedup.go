@@ -0,0 +1,76 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// DEDUP_COUNTER_RETENTION keeps the number of suppressed duplicates of a
+	// message around until it is delivered again
+	DEDUP_COUNTER_RETENTION = 7 * 24 * time.Hour
+)
+
+// Dedup reports whether a message with the given hash was already seen for
+// the application within `window`. If not, the message is remembered for
+// `window` and the number of duplicates that were suppressed before is
+// returned and reset.
+func (st *Store) Dedup(
+	token string,
+	hash string,
+	window time.Duration,
+) (bool, int64, error) {
+	if st.Enabled() == false {
+		return false, 0, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	first, err := st.client.SetNX(ctx, key("dedup", token, hash), 1, window).
+		Result()
+	if err != nil {
+		return false, 0, err
+	}
+
+	counterKey := key("dedup", token, hash, "count")
+	if first == false {
+		pipe := st.client.TxPipeline()
+		pipe.Incr(ctx, counterKey)
+		pipe.Expire(ctx, counterKey, DEDUP_COUNTER_RETENTION)
+		_, err := pipe.Exec(ctx)
+		return true, 0, err
+	}
+
+	repeated, err := st.client.GetDel(ctx, counterKey).Int64()
+	if err != nil && errors.Is(err, redis.Nil) == false {
+		return false, 0, err
+	}
+
+	return false, repeated, nil
+}
+
+// ReleaseDedup forgets the message with the given hash, e.g. because it could
+// not be enqueued, so that it is not considered a duplicate when retried. The
+// `repeated` duplicates that Dedup returned are being restored.
+func (st *Store) ReleaseDedup(token string, hash string, repeated int64) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	counterKey := key("dedup", token, hash, "count")
+	pipe := st.client.TxPipeline()
+	pipe.Del(ctx, key("dedup", token, hash))
+	if repeated > 0 {
+		pipe.IncrBy(ctx, counterKey, repeated)
+		pipe.Expire(ctx, counterKey, DEDUP_COUNTER_RETENTION)
+	}
+	_, err := pipe.Exec(ctx)
+	return err
+}
@@ -0,0 +1,89 @@
+package store
+
+import (
+	"context"
+	"strconv"
+	"time"
+)
+
+// AddDigest appends a message to the application's pending digest.
+func (st *Store) AddDigest(token string, data []byte) error {
+	return st.pushList(key("digest", token), data)
+}
+
+// GetDigest returns all messages of the application's pending digest, oldest
+// first.
+func (st *Store) GetDigest(token string) ([][]byte, error) {
+	return st.getList(key("digest", token))
+}
+
+// RemoveDigest removes the `n` oldest messages from the application's pending
+// digest, keeping the ones that were added in the meantime.
+func (st *Store) RemoveDigest(token string, n int) error {
+	return st.trimList(key("digest", token), n)
+}
+
+// AddHeld appends a message to the application's messages that are held back
+// until the quiet hours end at `until`.
+func (st *Store) AddHeld(token string, until int64, data []byte) error {
+	return st.pushList(heldKey(token, until), data)
+}
+
+// GetHeld returns all messages the application holds back until `until`,
+// oldest first.
+func (st *Store) GetHeld(token string, until int64) ([][]byte, error) {
+	return st.getList(heldKey(token, until))
+}
+
+// RemoveHeld removes the `n` oldest messages the application holds back until
+// `until`, keeping the ones that were added in the meantime.
+func (st *Store) RemoveHeld(token string, until int64, n int) error {
+	return st.trimList(heldKey(token, until), n)
+}
+
+func heldKey(token string, until int64) string {
+	return key("held", token, strconv.FormatInt(until, 10))
+}
+
+func (st *Store) pushList(k string, data []byte) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.RPush(ctx, k, data).Err()
+}
+
+func (st *Store) getList(k string) ([][]byte, error) {
+	if st.Enabled() == false {
+		return [][]byte{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := st.client.LRange(ctx, k, 0, -1).Result()
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	data := make([][]byte, 0, len(items))
+	for _, item := range items {
+		data = append(data, []byte(item))
+	}
+
+	return data, nil
+}
+
+func (st *Store) trimList(k string, n int) error {
+	if st.Enabled() == false || n <= 0 {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.LTrim(ctx, k, int64(n), -1).Err()
+}
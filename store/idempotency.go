@@ -0,0 +1,106 @@
+package store
+
+import (
+	"context"
+	"errors"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// IDEMPOTENCY_RETENTION is the time an idempotency key is being remembered,
+	// during which requests with the same key are not processed again.
+	IDEMPOTENCY_RETENTION = 24 * time.Hour
+)
+
+// ClaimIdempotencyKey remembers the request ID for the application's
+// idempotency key, unless the key was already claimed by another request, in
+// which case the ID of that request is returned.
+func (st *Store) ClaimIdempotencyKey(
+	token string,
+	idempotencyKey string,
+	requestID string,
+) (string, error) {
+	if st.Enabled() == false {
+		return requestID, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	k := key("idempotency", token, idempotencyKey)
+	claimed, err := st.client.SetNX(ctx, k, requestID, IDEMPOTENCY_RETENTION).
+		Result()
+	if err != nil {
+		return "", err
+	}
+	if claimed == true {
+		return requestID, nil
+	}
+
+	return st.client.Get(ctx, k).Result()
+}
+
+// SetIdempotencyResponse remembers the response to the request that claimed
+// the application's idempotency key, so that it can be replayed.
+func (st *Store) SetIdempotencyResponse(
+	token string,
+	idempotencyKey string,
+	response []byte,
+) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.Set(
+		ctx,
+		key("idempotency", token, idempotencyKey, "response"),
+		response,
+		IDEMPOTENCY_RETENTION,
+	).Err()
+}
+
+// GetIdempotencyResponse returns the response to the request that claimed the
+// application's idempotency key, which is empty while the request is still
+// being processed.
+func (st *Store) GetIdempotencyResponse(
+	token string,
+	idempotencyKey string,
+) ([]byte, error) {
+	if st.Enabled() == false {
+		return []byte{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	response, err := st.client.Get(
+		ctx,
+		key("idempotency", token, idempotencyKey, "response"),
+	).Bytes()
+	if err != nil && errors.Is(err, redis.Nil) == false {
+		return []byte{}, err
+	}
+
+	return response, nil
+}
+
+// ReleaseIdempotencyKey forgets the application's idempotency key, so that a
+// request that failed can be retried.
+func (st *Store) ReleaseIdempotencyKey(
+	token string,
+	idempotencyKey string,
+) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.Del(ctx, key("idempotency", token, idempotencyKey)).Err()
+}
@@ -0,0 +1,68 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// AddPendingReceipt remembers the receipt of an emergency message that was
+// sent to the destination, so that the destination can acknowledge it by
+// replying. Only the `max` most recent receipts are kept per destination.
+func (st *Store) AddPendingReceipt(
+	destination string,
+	receiptID string,
+	max int64,
+	ttl time.Duration,
+) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	k := key("pending", destination)
+	pipe := st.client.TxPipeline()
+	pipe.ZAdd(ctx, k, redis.Z{
+		Score:  float64(time.Now().UnixNano()),
+		Member: receiptID,
+	})
+	pipe.ZRemRangeByRank(ctx, k, 0, -(max + 1))
+	pipe.Expire(ctx, k, ttl)
+	_, err := pipe.Exec(ctx)
+	return err
+}
+
+// GetPendingReceipts returns the destination's pending receipts, the most
+// recent first.
+func (st *Store) GetPendingReceipts(destination string) ([]string, error) {
+	if st.Enabled() == false {
+		return []string{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.ZRevRange(ctx, key("pending", destination), 0, -1).Result()
+}
+
+// RemovePendingReceipt removes the receipt from the destination's pending
+// receipts and reports whether it was still pending, so that concurrent
+// replies only acknowledge it once.
+func (st *Store) RemovePendingReceipt(
+	destination string,
+	receiptID string,
+) (bool, error) {
+	if st.Enabled() == false {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	removed, err := st.client.ZRem(ctx, key("pending", destination), receiptID).
+		Result()
+	return removed > 0, err
+}
@@ -0,0 +1,139 @@
+package store
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/mrusme/overpush/models/receipt"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// RECEIPT_RETENTION is the time a receipt is kept around after the
+	// emergency message has expired, so that clients can still query it.
+	RECEIPT_RETENTION = 7 * 24 * time.Hour
+	// RECEIPT_UPDATE_ATTEMPTS is the number of times an update is retried if
+	// the receipt was changed concurrently
+	RECEIPT_UPDATE_ATTEMPTS = 10
+)
+
+func receiptTTL(rcpt receipt.Receipt) time.Duration {
+	return time.Until(time.Unix(rcpt.ExpiresAt, 0)) + RECEIPT_RETENTION
+}
+
+func (st *Store) GetReceipt(id string) (receipt.Receipt, error) {
+	if st.Enabled() == false {
+		return receipt.Receipt{}, errors.New("Receipt not found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	data, err := st.client.Get(ctx, key("receipt", id)).Bytes()
+	if err == redis.Nil {
+		return receipt.Receipt{}, errors.New("Receipt not found")
+	} else if err != nil {
+		return receipt.Receipt{}, err
+	}
+
+	var rcpt receipt.Receipt
+	if err := json.Unmarshal(data, &rcpt); err != nil {
+		return receipt.Receipt{}, err
+	}
+
+	return rcpt, nil
+}
+
+func (st *Store) SaveReceipt(rcpt receipt.Receipt) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	data, err := json.Marshal(rcpt)
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	ttl := receiptTTL(rcpt)
+	pipe := st.client.TxPipeline()
+	pipe.Set(ctx, key("receipt", rcpt.ID), data, ttl)
+	for _, tag := range rcpt.Tags {
+		tagKey := key("receipts", "tag", rcpt.Token, tag)
+		pipe.SAdd(ctx, tagKey, rcpt.ID)
+		pipe.Expire(ctx, tagKey, ttl)
+	}
+	_, err = pipe.Exec(ctx)
+	return err
+}
+
+// UpdateReceipt applies the update to the receipt atomically: if the receipt
+// is changed concurrently (e.g. acknowledged while the worker marks it
+// delivered), the update is applied again on the changed receipt.
+func (st *Store) UpdateReceipt(
+	id string,
+	update func(rcpt *receipt.Receipt) error,
+) (receipt.Receipt, error) {
+	if st.Enabled() == false {
+		return receipt.Receipt{}, errors.New("Receipt not found")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	var rcpt receipt.Receipt
+	k := key("receipt", id)
+	txf := func(tx *redis.Tx) error {
+		data, err := tx.Get(ctx, k).Bytes()
+		if err == redis.Nil {
+			return errors.New("Receipt not found")
+		} else if err != nil {
+			return err
+		}
+
+		rcpt = receipt.Receipt{}
+		if err := json.Unmarshal(data, &rcpt); err != nil {
+			return err
+		}
+		if err := update(&rcpt); err != nil {
+			return err
+		}
+
+		if data, err = json.Marshal(rcpt); err != nil {
+			return err
+		}
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.Set(ctx, k, data, receiptTTL(rcpt))
+			return nil
+		})
+		return err
+	}
+
+	for i := 0; i < RECEIPT_UPDATE_ATTEMPTS; i++ {
+		err := st.client.Watch(ctx, txf, k)
+		if err == redis.TxFailedErr {
+			continue
+		}
+		if err != nil {
+			return receipt.Receipt{}, err
+		}
+		return rcpt, nil
+	}
+
+	return receipt.Receipt{}, errors.New("Receipt was changed concurrently")
+}
+
+func (st *Store) GetReceiptIDsByTag(token string, tag string) ([]string, error) {
+	if st.Enabled() == false {
+		return []string{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.SMembers(ctx, key("receipts", "tag", token, tag)).Result()
+}
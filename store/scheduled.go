@@ -0,0 +1,65 @@
+package store
+
+import (
+	"context"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// ScheduledEntry references a scheduled message task.
+type ScheduledEntry struct {
+	ID     string
+	SendAt int64
+}
+
+func (st *Store) AddScheduled(token string, id string, sendAt int64) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.ZAdd(ctx, key("scheduled", token), redis.Z{
+		Score:  float64(sendAt),
+		Member: id,
+	}).Err()
+}
+
+func (st *Store) RemoveScheduled(token string, id string) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.ZRem(ctx, key("scheduled", token), id).Err()
+}
+
+func (st *Store) GetScheduled(token string) ([]ScheduledEntry, error) {
+	if st.Enabled() == false {
+		return []ScheduledEntry{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	zs, err := st.client.ZRangeWithScores(ctx, key("scheduled", token), 0, -1).
+		Result()
+	if err != nil {
+		return []ScheduledEntry{}, err
+	}
+
+	entries := make([]ScheduledEntry, 0, len(zs))
+	for _, z := range zs {
+		id, ok := z.Member.(string)
+		if !ok {
+			continue
+		}
+		entries = append(entries, ScheduledEntry{ID: id, SendAt: int64(z.Score)})
+	}
+
+	return entries, nil
+}
@@ -0,0 +1,99 @@
+package store
+
+import (
+	"errors"
+
+	"github.com/hibiken/asynq"
+	"github.com/mrusme/overpush/config"
+	"github.com/redis/go-redis/v9"
+	"go.uber.org/zap"
+)
+
+const (
+	KEY_PREFIX = "overpush:"
+
+	// QUEUE is the asynq queue that the API and the worker enqueue tasks to
+	QUEUE = "default"
+)
+
+// Store persists state that needs to be shared between the API and the
+// worker (e.g. receipts) in the same Redis that is being used by asynq.
+type Store struct {
+	cfg *config.Config
+	log *zap.Logger
+
+	client redis.UniversalClient
+}
+
+func New(cfg *config.Config, log *zap.Logger) (*Store, error) {
+	st := new(Store)
+	st.cfg = cfg
+	st.log = log
+
+	if st.cfg.Testing == true {
+		st.log.Debug("Store not enabled",
+			zap.Bool("Testing", st.cfg.Testing))
+		return st, nil
+	}
+
+	var connOpt asynq.RedisConnOpt
+	if st.cfg.Redis.Cluster == false {
+		if st.cfg.Redis.Failover == false {
+			connOpt = asynq.RedisClientOpt{
+				Addr:     st.cfg.Redis.Connection,
+				Username: st.cfg.Redis.Username,
+				Password: st.cfg.Redis.Password,
+			}
+		} else {
+			connOpt = asynq.RedisFailoverClientOpt{
+				MasterName:    st.cfg.Redis.MasterName,
+				SentinelAddrs: st.cfg.Redis.Connections,
+				Username:      st.cfg.Redis.Username,
+				Password:      st.cfg.Redis.Password,
+			}
+		}
+	} else {
+		connOpt = asynq.RedisClusterClientOpt{
+			Addrs:    st.cfg.Redis.Connections,
+			Username: st.cfg.Redis.Username,
+			Password: st.cfg.Redis.Password,
+		}
+	}
+
+	client, ok := connOpt.MakeRedisClient().(redis.UniversalClient)
+	if !ok {
+		return nil, errors.New("Could not create Redis client for store")
+	}
+	st.client = client
+
+	st.log.Info("Store initialized")
+	return st, nil
+}
+
+func (st *Store) Enabled() bool {
+	return st.client != nil
+}
+
+// Client returns the underlying Redis client, so that e.g. an asynq client can
+// share the store's connection. It returns nil if the store is not enabled.
+func (st *Store) Client() redis.UniversalClient {
+	return st.client
+}
+
+func (st *Store) Shutdown() error {
+	if st.client != nil {
+		return st.client.Close()
+	}
+	return nil
+}
+
+func key(parts ...string) string {
+	k := KEY_PREFIX
+	for i, part := range parts {
+		if i > 0 {
+			k += ":"
+		}
+		k += part
+	}
+	return k
+}
@@ -0,0 +1,54 @@
+package store
+
+import (
+	"context"
+	"time"
+)
+
+// SetSubscription adds or updates a push subscription of the user.
+func (st *Store) SetSubscription(userKey string, id string, data []byte) error {
+	if st.Enabled() == false {
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	return st.client.HSet(ctx, key("subscriptions", userKey), id, data).Err()
+}
+
+// GetSubscriptions returns all push subscriptions of the user.
+func (st *Store) GetSubscriptions(userKey string) ([][]byte, error) {
+	if st.Enabled() == false {
+		return [][]byte{}, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	items, err := st.client.HVals(ctx, key("subscriptions", userKey)).Result()
+	if err != nil {
+		return [][]byte{}, err
+	}
+
+	data := make([][]byte, 0, len(items))
+	for _, item := range items {
+		data = append(data, []byte(item))
+	}
+
+	return data, nil
+}
+
+// RemoveSubscription removes a push subscription of the user and reports
+// whether it existed.
+func (st *Store) RemoveSubscription(userKey string, id string) (bool, error) {
+	if st.Enabled() == false {
+		return false, nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	n, err := st.client.HDel(ctx, key("subscriptions", userKey), id).Result()
+	return n > 0, err
+}
@@ -0,0 +1,323 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"github.com/mrusme/overpush/store"
+	"go.uber.org/zap"
+)
+
+const (
+	TASK_MESSAGE  = "message"
+	TASK_DELIVERY = "delivery"
+	TASK_CALLBACK = "callback"
+	TASK_DIGEST   = "digest"
+	TASK_QUIET    = "quiet"
+
+	// DELIVERY_RETENTION keeps completed delivery tasks around, so that their
+	// task IDs prevent a retried message from delivering to a target twice.
+	DELIVERY_RETENTION = 1 * time.Hour
+)
+
+// Delivery is the payload of a TASK_DELIVERY task, which delivers a message to
+// a single target.
+type Delivery struct {
+	// MessageID is the task ID of the TASK_MESSAGE task the delivery belongs to
+	MessageID  string                 `json:"message_id"`
+	Message    message.Message        `json:"message"`
+	TargetID   string                 `json:"target_id"`
+	TargetArgs map[string]interface{} `json:"target_args"`
+	// Device is the name of the user's device the delivery is for, if any
+	Device string `json:"device"`
+
+	// Fallback is the position in the application's fallback chain, with 0
+	// being the application's own target(s)
+	Fallback int `json:"fallback"`
+	// StartedAt is the time of the first delivery attempt to this target
+	StartedAt int64 `json:"started_at"`
+}
+
+// GetID returns the ID of the delivery, which is derived from the message's
+// task ID, the target ID and the device, or an empty string if the message
+// has no task ID.
+func (dlv *Delivery) GetID() string {
+	if dlv.MessageID == "" {
+		return ""
+	}
+
+	id := fmt.Sprintf("%s:%s", dlv.MessageID, dlv.TargetID)
+	if dlv.Device != "" {
+		id = fmt.Sprintf("%s:%s", id, dlv.Device)
+	}
+	return id
+}
+
+// NewMessageTask creates the task that is being enqueued by the API for every
+// incoming message. It only fans out into delivery tasks, hence the retry and
+// timeout options of the actual deliveries are configured per target.
+func NewMessageTask(payload []byte, opts ...asynq.Option) *asynq.Task {
+	return asynq.NewTask(
+		TASK_MESSAGE,
+		payload,
+		append([]asynq.Option{
+			asynq.Queue(store.QUEUE),
+			asynq.MaxRetry(5),
+			asynq.Timeout(5 * time.Minute),
+		}, opts...)...,
+	)
+}
+
+// enqueueDelivery enqueues the delivery to the target as its own task, using
+// the target's retry and timeout configuration. Without Redis (e.g. when
+// `Testing` is enabled) the delivery is performed right away.
+//
+// The task ID is derived from the message's task ID, the target ID and the
+// device, so that every message is delivered to a target (or device) only
+// once, even if the message task is retried or multiple deliveries fall back
+// to the same target.
+func (wrk *Worker) enqueueDelivery(dlv Delivery, tgt target.Target) error {
+	if wrk.client == nil {
+		return wrk.deliver(context.Background(), dlv)
+	}
+
+	payload, err := json.Marshal(dlv)
+	if err != nil {
+		return err
+	}
+
+	opts := []asynq.Option{
+		asynq.Queue(store.QUEUE),
+		asynq.MaxRetry(tgt.GetMaxRetry()),
+		asynq.Timeout(tgt.GetTimeout()),
+		asynq.Retention(DELIVERY_RETENTION),
+	}
+	if expiresAt := dlv.Message.GetExpiresAt(); expiresAt > 0 {
+		opts = append(opts, asynq.Deadline(time.Unix(expiresAt, 0)))
+	}
+	if id := dlv.GetID(); id != "" {
+		opts = append(opts, asynq.TaskID(id))
+	}
+
+	_, err = wrk.client.Enqueue(asynq.NewTask(TASK_DELIVERY, payload), opts...)
+	if err != nil && errors.Is(err, asynq.ErrTaskIDConflict) == false {
+		wrk.log.Error("Worker failed to enqueue delivery",
+			zap.String("Target.ID", tgt.ID),
+			zap.Error(err))
+		return err
+	}
+
+	return nil
+}
+
+func (wrk *Worker) HandleDelivery(ctx context.Context, t *asynq.Task) error {
+	var dlv Delivery
+	if err := json.Unmarshal(t.Payload(), &dlv); err != nil {
+		return err
+	}
+
+	return wrk.deliver(ctx, dlv)
+}
+
+func (wrk *Worker) deliver(ctx context.Context, dlv Delivery) error {
+	m := dlv.Message
+	m.SetDeliveryID(dlv.GetID())
+
+	wrk.log.Debug("Working on delivery",
+		zap.String("Target.ID", dlv.TargetID))
+
+	if m.IsExpired() == true {
+		wrk.expire(m)
+		return nil
+	}
+
+	if wrk.handlesEmergency(m) == true {
+		active, err := wrk.isEmergencyActive(m)
+		if err != nil {
+			return err
+		}
+		if active == false {
+			wrk.log.Debug("Worker disregarding delivery, emergency not active anymore",
+				zap.String("Receipt", m.GetReceipt()))
+			return nil
+		}
+	}
+
+	app, err := wrk.repos.Application.GetApplication(m.User, m.Token)
+	if err != nil {
+		wrk.log.Debug("Worker encountered error for User.GetApplication",
+			zap.Error(err))
+		return err
+	}
+	if m.IsViaSubmit() == false && app.Enable == false {
+		wrk.log.Debug("Worker disregarding delivery, application not enabled",
+			zap.String("Application.Token", app.Token))
+		return nil
+	}
+
+	tgt, err := wrk.repos.Target.GetTargetByID(dlv.TargetID)
+	if err != nil {
+		wrk.log.Debug("Worker encountered error for Target.GetTargetByID",
+			zap.Error(err))
+		return err
+	}
+	if m.IsViaSubmit() == false && tgt.Enable == false {
+		wrk.log.Debug("Worker disregarding delivery, target not enabled",
+			zap.String("Target.ID", tgt.ID))
+		return nil
+	}
+
+	wrk.log.Debug("Worker checking encryption requirements",
+		zap.String("EncryptionType", app.EncryptionType))
+	if app.EncryptionType == "age" {
+		if err = wrk.EncryptWithAge(
+			&m,
+			app.EncryptionRecipients,
+			app.EncryptTitle,
+			app.EncryptMessage,
+			app.EncryptAttachment); err != nil {
+			wrk.log.Error("Worker encryption failed",
+				zap.Error(err))
+			return err
+		}
+	}
+
+	wrk.log.Debug("Worker executing target",
+		zap.String("Target.Type", tgt.Type),
+		zap.Any("Target.Args", tgt.Args),
+		zap.Any("Application.TargetArgs", dlv.TargetArgs),
+	)
+
+	if err := wrk.ts.Execute(
+		tgt.ID,
+		m,
+		dlv.TargetArgs,
+	); err != nil {
+		wrk.log.Debug("Worker target execution failed",
+			zap.String("Target.ID", tgt.ID),
+			zap.Error(err))
+		permanent := target.IsPermanent(err)
+		if wrk.fallBack(ctx, dlv, app, permanent) == true {
+			return nil
+		}
+		if permanent == true {
+			return fmt.Errorf("%w: %w", err, asynq.SkipRetry)
+		}
+		return err
+	}
+
+	if m.IsViaSubmit() == false {
+		if err = wrk.repos.Application.IncrementStat(
+			"No need when DB",
+			app.Token,
+			"sent",
+		); err != nil {
+			wrk.log.Error("Application stat not increased",
+				zap.String("stat", "sent"),
+				zap.Error(err))
+		}
+	}
+
+	return nil
+}
+
+// fallBack hands a failed delivery to the next target in the application's
+// fallback chain, if the fallback's thresholds are reached or the delivery has
+// no retries left (or failed permanently). It returns true if the delivery was
+// handed over, in which case the failed delivery is not retried any further.
+func (wrk *Worker) fallBack(
+	ctx context.Context,
+	dlv Delivery,
+	app application.Application,
+	permanent bool,
+) bool {
+	next, ok := app.GetFallback(dlv.Fallback + 1)
+	if !ok {
+		return false
+	}
+
+	// Outside of asynq (e.g. when `Testing` is enabled) there are no retries
+	final := true
+	retried, ok := asynq.GetRetryCount(ctx)
+	if ok && permanent == false {
+		maxRetry, _ := asynq.GetMaxRetry(ctx)
+		final = retried >= maxRetry
+	}
+
+	if final == false &&
+		next.ShouldFallBack(retried+1, time.Unix(dlv.StartedAt, 0)) == false {
+		return false
+	}
+
+	// Skip fallbacks whose targets are not available
+	for n := dlv.Fallback + 1; ; n++ {
+		fb, ok := app.GetFallback(n)
+		if !ok {
+			return false
+		}
+
+		tgt, err := wrk.repos.Target.GetTargetByID(fb.Target)
+		if err != nil ||
+			(dlv.Message.IsViaSubmit() == false && tgt.Enable == false) {
+			wrk.log.Debug("Worker skipping unavailable fallback",
+				zap.String("Target.ID", fb.Target),
+				zap.Error(err))
+			continue
+		}
+
+		if err := wrk.enqueueDelivery(Delivery{
+			MessageID:  dlv.MessageID,
+			Message:    dlv.Message,
+			TargetID:   tgt.ID,
+			TargetArgs: app.GetTargetArgs(tgt.ID),
+			Fallback:   n,
+			StartedAt:  time.Now().Unix(),
+		}, tgt); err != nil {
+			return false
+		}
+
+		wrk.log.Info("Worker fell back to next target",
+			zap.String("From", dlv.TargetID),
+			zap.String("To", tgt.ID),
+			zap.Int("Retried", retried))
+		return true
+	}
+}
+
+// retryDelay applies the delay requested by the target or the target's
+// backoff configuration to retried deliveries and falls back to asynq's
+// default for everything else.
+func (wrk *Worker) retryDelay(n int, e error, t *asynq.Task) time.Duration {
+	// Targets can ask for a specific delay, e.g. when being rate limited
+	if delay, ok := target.GetRetryAfter(e); ok {
+		return delay
+	}
+
+	// There is no point in waiting for retries of expired messages, which are
+	// going to fail right away until asynq archives them
+	if m, ok := taskMessage(t); ok && m.IsExpired() == true {
+		return 0
+	}
+
+	if t.Type() == TASK_DELIVERY {
+		var dlv Delivery
+		if err := json.Unmarshal(t.Payload(), &dlv); err == nil {
+			if tgt, err := wrk.repos.Target.GetTargetByID(
+				dlv.TargetID,
+			); err == nil {
+				if delay, ok := tgt.GetRetryDelay(n); ok {
+					return delay
+				}
+			}
+		}
+	}
+
+	return asynq.DefaultRetryDelayFunc(n, e, t)
+}
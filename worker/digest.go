@@ -0,0 +1,124 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/store"
+	"go.uber.org/zap"
+)
+
+// DigestFlush is the payload of a TASK_DIGEST task, which delivers the pending
+// digest of an application.
+type DigestFlush struct {
+	User  string `json:"user"`
+	Token string `json:"token"`
+}
+
+// addToDigest buffers the message if the application's digest accepts it and
+// makes sure the digest is being delivered at the end of the current
+// interval. Without Redis (e.g. when `Testing` is enabled) messages are
+// delivered right away. It returns true if the message was buffered.
+func (wrk *Worker) addToDigest(
+	app application.Application,
+	m message.Message,
+) bool {
+	if wrk.client == nil || app.Digest.Accepts(m) == false {
+		return false
+	}
+
+	if err := wrk.repos.Digest.AddToDigest(m); err != nil {
+		wrk.log.Error("Worker failed to add message to digest",
+			zap.String("Application.Token", app.Token),
+			zap.Error(err))
+		return false
+	}
+
+	payload, err := json.Marshal(DigestFlush{User: m.User, Token: m.Token})
+	if err != nil {
+		return true
+	}
+
+	// Every message within the same interval schedules the same task, of which
+	// only the first one is being enqueued
+	at := app.Digest.NextAt(time.Now())
+	_, err = wrk.client.Enqueue(
+		asynq.NewTask(TASK_DIGEST, payload),
+		asynq.Queue(store.QUEUE),
+		asynq.TaskID(fmt.Sprintf("digest:%s:%d", m.Token, at.Unix())),
+		asynq.ProcessAt(at),
+	)
+	if err != nil && errors.Is(err, asynq.ErrTaskIDConflict) == false {
+		// Note: The message is not lost, as the next message added to the digest
+		// schedules the delivery again.
+		wrk.log.Error("Worker failed to schedule digest",
+			zap.String("Application.Token", app.Token),
+			zap.Error(err))
+	}
+
+	wrk.log.Debug("Worker added message to digest",
+		zap.String("Application.Token", app.Token),
+		zap.Time("At", at))
+	return true
+}
+
+// HandleDigest combines the application's buffered messages into a single
+// message, which is then being processed like any other message.
+func (wrk *Worker) HandleDigest(ctx context.Context, t *asynq.Task) error {
+	var flush DigestFlush
+	if err := json.Unmarshal(t.Payload(), &flush); err != nil {
+		return err
+	}
+
+	app, err := wrk.repos.Application.GetApplication(flush.User, flush.Token)
+	if err != nil {
+		wrk.log.Debug("Worker encountered error for User.GetApplication",
+			zap.Error(err))
+		return err
+	}
+
+	msgs, n, err := wrk.repos.Digest.GetDigest(flush.Token)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	if len(msgs) > 0 {
+		m, err := app.Digest.Render(app.Name, msgs)
+		if err != nil {
+			wrk.log.Error("Worker failed to render digest",
+				zap.String("Application.Token", app.Token),
+				zap.Error(err))
+			return err
+		}
+
+		payload, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		_, err = wrk.client.Enqueue(
+			NewMessageTask(
+				payload,
+				asynq.TaskID(fmt.Sprintf("%s:message", taskID(t))),
+			),
+		)
+		if err != nil && errors.Is(err, asynq.ErrTaskIDConflict) == false {
+			return err
+		}
+
+		wrk.log.Debug("Worker delivering digest",
+			zap.String("Application.Token", app.Token),
+			zap.Int("Messages", len(msgs)))
+	}
+
+	return wrk.repos.Digest.RemoveFromDigest(flush.Token, n)
+}
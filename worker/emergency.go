@@ -0,0 +1,163 @@
+package worker
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/store"
+	"go.uber.org/zap"
+)
+
+const (
+	// CALLBACK_TIMEOUT limits the callback request, which must finish within
+	// the callback task's timeout
+	CALLBACK_TIMEOUT = 30 * time.Second
+)
+
+// handlesEmergency reports whether the message is an emergency-priority message
+// that requires receipt handling. Without Redis (e.g. when `Testing` is
+// enabled) emergency messages are delivered only once.
+func (wrk *Worker) handlesEmergency(m message.Message) bool {
+	return m.IsEmergency() == true &&
+		m.GetReceipt() != "" &&
+		wrk.client != nil
+}
+
+func (wrk *Worker) isEmergencyActive(m message.Message) (bool, error) {
+	rcpt, err := wrk.repos.Receipt.GetReceipt(m.GetReceipt())
+	if err != nil {
+		return false, err
+	}
+
+	return rcpt.IsActive(), nil
+}
+
+// scheduleEmergencyRedelivery marks the emergency message as delivered and
+// enqueues the next delivery `Retry` seconds from now, unless the receipt has
+// become inactive or would expire before then.
+func (wrk *Worker) scheduleEmergencyRedelivery(
+	t *asynq.Task,
+	m message.Message,
+) error {
+	rcpt, err := wrk.repos.Receipt.MarkDelivered(m.GetReceipt())
+	if err != nil {
+		return err
+	}
+
+	if rcpt.IsActive() == false {
+		return nil
+	}
+
+	retry := time.Duration(rcpt.Retry) * time.Second
+	if time.Now().Add(retry).Unix() >= rcpt.ExpiresAt {
+		wrk.log.Debug("Worker not scheduling emergency redelivery, expires before",
+			zap.String("Receipt", rcpt.ID))
+		return nil
+	}
+
+	// The task ID makes sure that every delivery is only being scheduled once,
+	// even if this job happens to be retried.
+	_, err = wrk.client.Enqueue(
+		NewMessageTask(
+			t.Payload(),
+			asynq.TaskID(fmt.Sprintf("%s-%d", rcpt.ID, rcpt.Deliveries)),
+			asynq.ProcessIn(retry),
+		),
+	)
+	if err != nil && errors.Is(err, asynq.ErrTaskIDConflict) == false {
+		return err
+	}
+
+	wrk.log.Debug("Worker scheduled emergency redelivery",
+		zap.String("Receipt", rcpt.ID),
+		zap.Int("Deliveries", rcpt.Deliveries),
+		zap.Duration("In", retry))
+	return nil
+}
+
+// AcknowledgeEmergency is being called by targets that are able to receive
+// acknowledgements from their recipients (e.g. XMPP replies). It stops further
+// redeliveries and enqueues the callback, if one was specified.
+func (wrk *Worker) AcknowledgeEmergency(receiptID string, device string) error {
+	rcpt, err := wrk.repos.Receipt.AcknowledgeReceipt(receiptID, device)
+	if err != nil {
+		return err
+	}
+
+	wrk.log.Info("Emergency acknowledged",
+		zap.String("Receipt", rcpt.ID),
+		zap.String("Device", device))
+
+	if rcpt.Callback == "" || wrk.client == nil {
+		return nil
+	}
+
+	_, err = wrk.client.Enqueue(
+		asynq.NewTask(TASK_CALLBACK, []byte(rcpt.ID)),
+		asynq.Queue(store.QUEUE),
+		asynq.TaskID(fmt.Sprintf("%s-callback", rcpt.ID)),
+		asynq.MaxRetry(5),
+		asynq.Timeout(1*time.Minute),
+	)
+	if err != nil && errors.Is(err, asynq.ErrTaskIDConflict) == false {
+		return err
+	}
+
+	return nil
+}
+
+// HandleCallback performs the Pushover-style callback request for an
+// acknowledged emergency message, see https://pushover.net/api/receipts#callback
+func (wrk *Worker) HandleCallback(ctx context.Context, t *asynq.Task) error {
+	rcpt, err := wrk.repos.Receipt.GetReceipt(string(t.Payload()))
+	if err != nil {
+		return err
+	}
+
+	if rcpt.CalledBack == true || rcpt.Callback == "" {
+		return nil
+	}
+
+	form := url.Values{}
+	form.Set("receipt", rcpt.ID)
+	form.Set("acknowledged", "1")
+	form.Set("acknowledged_at", strconv.FormatInt(rcpt.AcknowledgedAt, 10))
+	form.Set("acknowledged_by", rcpt.AcknowledgedBy)
+	form.Set("acknowledged_by_device", rcpt.AcknowledgedByDevice)
+
+	req, err := http.NewRequestWithContext(
+		ctx,
+		http.MethodPost,
+		rcpt.Callback,
+		strings.NewReader(form.Encode()),
+	)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	client := &http.Client{Timeout: CALLBACK_TIMEOUT}
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Callback returned status %d", resp.StatusCode)
+	}
+
+	wrk.log.Debug("Worker called back",
+		zap.String("Receipt", rcpt.ID),
+		zap.String("Callback", rcpt.Callback))
+
+	return wrk.repos.Receipt.MarkCalledBack(rcpt.ID)
+}
@@ -0,0 +1,72 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/mrusme/overpush/models/message"
+	"go.uber.org/zap"
+)
+
+// taskMessage returns the message carried by a TASK_MESSAGE or TASK_DELIVERY
+// task.
+func taskMessage(t *asynq.Task) (message.Message, bool) {
+	switch t.Type() {
+	case TASK_MESSAGE:
+		var m message.Message
+		if err := json.Unmarshal(t.Payload(), &m); err == nil {
+			return m, true
+		}
+	case TASK_DELIVERY:
+		var dlv Delivery
+		if err := json.Unmarshal(t.Payload(), &dlv); err == nil {
+			return dlv.Message, true
+		}
+	}
+
+	return message.Message{}, false
+}
+
+// expire records a message that was dropped because its TTL has passed.
+func (wrk *Worker) expire(m message.Message) {
+	wrk.log.Info("Worker dropping message, TTL has passed",
+		zap.String("Application.Token", m.Token),
+		zap.Int("TTL", m.TTL),
+		zap.Time("ExpiresAt", time.Unix(m.GetExpiresAt(), 0)))
+
+	if m.IsViaSubmit() == false {
+		if err := wrk.repos.Application.IncrementStat(
+			"No need when DB",
+			m.Token,
+			"expired",
+		); err != nil {
+			wrk.log.Error("Application stat not increased",
+				zap.String("stat", "expired"),
+				zap.Error(err))
+		}
+	}
+}
+
+// handleError records messages that asynq gave up on because their deadline,
+// which the API derives from the message TTL, was exceeded before the task
+// could be processed.
+func (wrk *Worker) handleError(ctx context.Context, t *asynq.Task, err error) {
+	if errors.Is(err, context.DeadlineExceeded) == false {
+		return
+	}
+
+	m, ok := taskMessage(t)
+	if !ok || m.IsExpired() == false {
+		return
+	}
+
+	// Only record the message once, when asynq archives it
+	retried, _ := asynq.GetRetryCount(ctx)
+	maxRetry, _ := asynq.GetMaxRetry(ctx)
+	if retried >= maxRetry {
+		wrk.expire(m)
+	}
+}
@@ -0,0 +1,196 @@
+package worker
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/hibiken/asynq"
+	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/quiethours"
+	"github.com/mrusme/overpush/models/user"
+	"github.com/mrusme/overpush/store"
+	"go.uber.org/zap"
+)
+
+// QuietHoursFlush is the payload of a TASK_QUIET task, which delivers the
+// messages an application held back once the quiet hours end at Until.
+type QuietHoursFlush struct {
+	User  string `json:"user"`
+	Token string `json:"token"`
+	Until int64  `json:"until"`
+}
+
+// holdForQuietHours checks the user's and the application's quiet hours and
+// either drops the message or holds it back until the quiet hours end, at
+// which point all messages held until then are delivered together. It returns
+// true if the message was dropped or held.
+func (wrk *Worker) holdForQuietHours(
+	usr user.User,
+	app application.Application,
+	m message.Message,
+) bool {
+	windows := append(append([]quiethours.Window{}, usr.QuietHours...),
+		app.QuietHours...)
+	if len(windows) == 0 {
+		return false
+	}
+
+	until, drop, active, err := quiethours.Until(windows, time.Now(), m.Priority)
+	if err != nil {
+		wrk.log.Error("Worker encountered error evaluating quiet hours",
+			zap.String("Application.Token", app.Token),
+			zap.Error(err))
+		return false
+	}
+	if active == false {
+		return false
+	}
+
+	if drop == true {
+		wrk.log.Debug("Worker disregarding job, quiet hours are active",
+			zap.String("Application.Token", app.Token),
+			zap.Time("Until", until))
+		return true
+	}
+
+	if wrk.client == nil {
+		wrk.log.Debug("Worker cannot hold message for quiet hours, delivering")
+		return false
+	}
+
+	// The message would expire before the quiet hours end
+	if expiresAt := m.GetExpiresAt(); expiresAt > 0 && expiresAt <= until.Unix() {
+		wrk.expire(m)
+		return true
+	}
+
+	if err := wrk.repos.Digest.AddToHeld(m, until.Unix()); err != nil {
+		wrk.log.Error("Worker failed to hold message for quiet hours",
+			zap.String("Application.Token", app.Token),
+			zap.Error(err))
+		return false
+	}
+
+	payload, err := json.Marshal(QuietHoursFlush{
+		User:  m.User,
+		Token: m.Token,
+		Until: until.Unix(),
+	})
+	if err != nil {
+		return true
+	}
+
+	// Every message held until the same time schedules the same task, of which
+	// only the first one is being enqueued
+	_, err = wrk.client.Enqueue(
+		asynq.NewTask(TASK_QUIET, payload),
+		asynq.Queue(store.QUEUE),
+		asynq.TaskID(fmt.Sprintf("quiet:%s:%d", m.Token, until.Unix())),
+		asynq.ProcessAt(until),
+	)
+	if err != nil && errors.Is(err, asynq.ErrTaskIDConflict) == false {
+		// Note: The message is not lost, as the next message that is being held
+		// schedules the delivery again.
+		wrk.log.Error("Worker failed to schedule end of quiet hours",
+			zap.String("Application.Token", app.Token),
+			zap.Error(err))
+	}
+
+	wrk.log.Debug("Worker holding message until quiet hours end",
+		zap.String("Application.Token", app.Token),
+		zap.Time("Until", until))
+	return true
+}
+
+// isBatchable reports whether the message can be combined with others without
+// losing anything, which is the case for plain-text messages without URL or
+// attachment.
+func isBatchable(m message.Message) bool {
+	return m.HTML == 0 &&
+		m.URL == "" &&
+		m.Attachment == "" &&
+		m.AttachmentBase64 == ""
+}
+
+// HandleQuietHours delivers the messages the application held back until the
+// end of the quiet hours as a batch. Plain-text messages are combined into a
+// single message using the application's `Digest` templates, while messages
+// with a URL, an attachment or HTML are delivered as they are, so that none of
+// their fields are lost. A single held message is delivered as is as well.
+func (wrk *Worker) HandleQuietHours(ctx context.Context, t *asynq.Task) error {
+	var flush QuietHoursFlush
+	if err := json.Unmarshal(t.Payload(), &flush); err != nil {
+		return err
+	}
+
+	app, err := wrk.repos.Application.GetApplication(flush.User, flush.Token)
+	if err != nil {
+		wrk.log.Debug("Worker encountered error for User.GetApplication",
+			zap.Error(err))
+		return err
+	}
+
+	held, n, err := wrk.repos.Digest.GetHeld(flush.Token, flush.Until)
+	if err != nil {
+		return err
+	}
+	if n == 0 {
+		return nil
+	}
+
+	var batch []message.Message
+	var msgs []message.Message
+	for _, m := range held {
+		if m.IsExpired() == true {
+			wrk.expire(m)
+			continue
+		}
+		if isBatchable(m) == true {
+			batch = append(batch, m)
+		} else {
+			msgs = append(msgs, m)
+		}
+	}
+
+	if len(batch) > 1 {
+		m, err := app.Digest.Render(app.Name, batch)
+		if err != nil {
+			wrk.log.Error("Worker failed to render held messages",
+				zap.String("Application.Token", app.Token),
+				zap.Error(err))
+			return err
+		}
+		msgs = append(msgs, m)
+	} else {
+		msgs = append(msgs, batch...)
+	}
+
+	for i, m := range msgs {
+		payload, err := json.Marshal(m)
+		if err != nil {
+			return err
+		}
+
+		_, err = wrk.client.Enqueue(
+			NewMessageTask(
+				payload,
+				asynq.TaskID(fmt.Sprintf("%s:message:%d", taskID(t), i)),
+			),
+		)
+		if err != nil && errors.Is(err, asynq.ErrTaskIDConflict) == false {
+			return err
+		}
+	}
+
+	if len(msgs) > 0 {
+		wrk.log.Debug("Worker delivering messages held for quiet hours",
+			zap.String("Application.Token", app.Token),
+			zap.Int("Messages", len(msgs)))
+	}
+
+	return wrk.repos.Digest.RemoveFromHeld(flush.Token, flush.Until, n)
+}
@@ -0,0 +1,44 @@
+package worker
+
+import (
+	"strings"
+
+	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/user"
+)
+
+func deviceRoutes(devices []user.Device) []application.Route {
+	var routes []application.Route
+
+	for _, device := range devices {
+		routes = append(routes, application.Route{
+			TargetID:   device.Target,
+			TargetArgs: device.TargetArgs,
+			Device:     device.Name,
+		})
+	}
+
+	return routes
+}
+
+// getRoutes returns the routes for a message. Just like with Pushover,
+// messages that name devices are delivered only to these devices. All other
+// messages are delivered through the application's rules and targets.
+func getRoutes(
+	app application.Application,
+	usr user.User,
+	m message.Message,
+) ([]application.Route, error) {
+	if len(usr.Devices) == 0 || strings.TrimSpace(m.Device) == "" {
+		return app.GetRoutes(m)
+	}
+
+	// Note: Unknown devices were rejected by the API already, but the user's
+	// devices might have changed in the meantime.
+	if devices, _ := usr.GetDevices(m.Device); len(devices) > 0 {
+		return deviceRoutes(devices), nil
+	}
+
+	return app.GetRoutes(m)
+}
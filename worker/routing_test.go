@@ -0,0 +1,65 @@
+package worker
+
+import (
+	"testing"
+
+	"github.com/mrusme/overpush/models/application"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/user"
+)
+
+func TestGetRoutes(t *testing.T) {
+	app := application.Application{
+		Enable:     true,
+		Target:     "default",
+		TargetArgs: map[string]interface{}{"destination": "app"},
+		Rules: []application.Rule{{
+			Message:    "(?i)urgent",
+			Targets:    []string{"pager"},
+			TargetArgs: map[string]interface{}{"destination": "oncall"},
+		}},
+	}
+	usr := user.User{
+		Enable: true,
+		Devices: []user.Device{
+			{
+				Name:       "phone",
+				Target:     "xmpp",
+				TargetArgs: map[string]interface{}{"destination": "me@xmpp"},
+			},
+			{
+				Name:       "laptop",
+				Target:     "matrix",
+				TargetArgs: map[string]interface{}{"destination": "!room"},
+			},
+		},
+	}
+
+	for _, tc := range []struct {
+		name   string
+		m      message.Message
+		target string
+		device string
+	}{
+		{"no device", message.Message{Message: "hello"}, "default", ""},
+		{"rule", message.Message{Message: "URGENT: disk full"}, "pager", ""},
+		{"device", message.Message{Message: "urgent", Device: "phone"}, "xmpp", "phone"},
+		{"unknown device", message.Message{Message: "hello", Device: "tablet"}, "default", ""},
+	} {
+		routes, err := getRoutes(app, usr, tc.m)
+		if err != nil {
+			t.Fatalf("%s: %v", tc.name, err)
+		}
+		if len(routes) != 1 || routes[0].TargetID != tc.target ||
+			routes[0].Device != tc.device {
+			t.Errorf("%s: routes = %+v, want target %q and device %q",
+				tc.name, routes, tc.target, tc.device)
+		}
+	}
+
+	routes, err := getRoutes(app, usr,
+		message.Message{Message: "hello", Device: "phone,laptop"})
+	if err != nil || len(routes) != 2 {
+		t.Errorf("routes = %+v (%v), want both devices", routes, err)
+	}
+}
@@ -0,0 +1,160 @@
+package acknowledge
+
+import (
+	"fmt"
+	"strings"
+
+	"go.uber.org/zap"
+)
+
+const (
+	// RECEIPT_CODE_LENGTH is the length of the receipt prefix that can be used
+	// to acknowledge a specific emergency message, e.g. "ack abc123"
+	RECEIPT_CODE_LENGTH = 6
+)
+
+// IPendingReceipts keeps track of the receipts that destinations can
+// acknowledge. It is backed by the store, so that replies can be processed
+// by any worker, including after a restart.
+type IPendingReceipts interface {
+	AddPendingReceipt(destination string, receiptID string) error
+	GetPendingReceipts(destination string) ([]string, error)
+	RemovePendingReceipt(destination string, receiptID string) (bool, error)
+}
+
+// Acknowledger processes replies to emergency messages for targets that are
+// able to receive them (e.g. XMPP or Matrix), which acknowledge the messages
+// with either "ack", "ack <code>" or just "<code>".
+type Acknowledger struct {
+	log *zap.Logger
+
+	acknowledge func(receiptID string, device string) error
+	pending     IPendingReceipts
+}
+
+func New(
+	log *zap.Logger,
+	acknowledge func(receiptID string, device string) error,
+	pending IPendingReceipts,
+) *Acknowledger {
+	a := new(Acknowledger)
+
+	a.log = log
+	a.acknowledge = acknowledge
+	a.pending = pending
+
+	return a
+}
+
+// Acknowledge acknowledges the receipt on behalf of the device.
+func (a *Acknowledger) Acknowledge(receiptID string, device string) error {
+	return a.acknowledge(receiptID, device)
+}
+
+// ReceiptCode returns the short code that identifies the receipt in replies.
+func ReceiptCode(receiptID string) string {
+	if len(receiptID) < RECEIPT_CODE_LENGTH {
+		return strings.ToLower(receiptID)
+	}
+	return strings.ToLower(receiptID[:RECEIPT_CODE_LENGTH])
+}
+
+// Instructions returns the text that tells the recipient how to acknowledge
+// the emergency message.
+func Instructions(receiptID string) string {
+	return fmt.Sprintf("Reply \"ack\" or \"ack %s\" to acknowledge.",
+		ReceiptCode(receiptID))
+}
+
+// AddPending remembers the receipt as acknowledgeable by the destination,
+// which identifies the conversation the message was sent to, e.g.
+// "xmpp:user@example.com".
+func (a *Acknowledger) AddPending(destination string, receiptID string) {
+	if err := a.pending.AddPendingReceipt(destination, receiptID); err != nil {
+		a.log.Error("Failed to remember pending receipt",
+			zap.String("Destination", destination),
+			zap.String("Receipt", receiptID),
+			zap.Error(err))
+	}
+}
+
+// popPending removes and returns the receipt that matches the code from the
+// destination's pending receipts. Without a code, the most recent receipt is
+// being returned.
+func (a *Acknowledger) popPending(
+	destination string,
+	code string,
+) (string, bool, error) {
+	ids, err := a.pending.GetPendingReceipts(destination)
+	if err != nil {
+		return "", false, err
+	}
+
+	for _, id := range ids {
+		if code != "" && ReceiptCode(id) != code {
+			continue
+		}
+
+		removed, err := a.pending.RemovePendingReceipt(destination, id)
+		if err != nil {
+			return "", false, err
+		}
+		// Otherwise another reply acknowledged the receipt in the meantime
+		if removed == true {
+			return id, true, nil
+		}
+	}
+
+	return "", false, nil
+}
+
+// HandleReply acknowledges pending emergency messages of the destination if
+// the text is an acknowledgement, in which case it returns the answer to send
+// to the device.
+func (a *Acknowledger) HandleReply(
+	destination string,
+	device string,
+	text string,
+) (string, bool) {
+	var code string
+	fields := strings.Fields(strings.ToLower(text))
+	switch {
+	case len(fields) == 1 && fields[0] == "ack":
+		code = ""
+	case len(fields) == 2 && fields[0] == "ack":
+		code = fields[1]
+	case len(fields) == 1 && len(fields[0]) == RECEIPT_CODE_LENGTH:
+		code = fields[0]
+	default:
+		return "", false
+	}
+
+	for {
+		receiptID, ok, err := a.popPending(destination, code)
+		if err != nil {
+			a.log.Error("Failed to retrieve pending receipts",
+				zap.String("Destination", destination),
+				zap.Error(err))
+			return "Could not acknowledge, please try again", true
+		}
+		if !ok {
+			if code != "" {
+				return "Nothing to acknowledge for " + code, true
+			}
+			return "Nothing to acknowledge", true
+		}
+
+		if err := a.Acknowledge(receiptID, device); err != nil {
+			a.log.Debug("Failed to acknowledge",
+				zap.String("Receipt", receiptID),
+				zap.Error(err))
+			if code != "" {
+				return "Could not acknowledge " + code, true
+			}
+			// Receipt is probably expired or cancelled already, try the next one
+			continue
+		}
+
+		return "Acknowledged " + ReceiptCode(receiptID), true
+	}
+}
@@ -0,0 +1,215 @@
+package apprise
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+var (
+	// ErrNoConfiguration is returned if the Apprise API has no configuration
+	// stored for the key
+	ErrNoConfiguration = errors.New("Apprise API has no configuration for key")
+	// ErrNotificationFailed is returned if the Apprise API failed to deliver
+	// the notification to at least one of the services
+	ErrNotificationFailed = errors.New("Apprise API failed to notify")
+)
+
+// APIError is returned for unsuccessful responses of the Apprise API.
+type APIError struct {
+	StatusCode int
+	Message    string
+}
+
+func (e *APIError) Error() string {
+	if e.Message == "" {
+		return fmt.Sprintf("Apprise API returned %d %s",
+			e.StatusCode, http.StatusText(e.StatusCode))
+	}
+	return fmt.Sprintf("Apprise API returned %d %s: %s",
+		e.StatusCode, http.StatusText(e.StatusCode), e.Message)
+}
+
+// notification is the request body of the Apprise API's notify endpoints.
+type notification struct {
+	URLs   string `json:"urls,omitempty"`
+	Tag    string `json:"tag,omitempty"`
+	Title  string `json:"title"`
+	Body   string `json:"body"`
+	Type   string `json:"type"`
+	Format string `json:"format"`
+	Attach string `json:"attach,omitempty"`
+}
+
+// getNotifyURL returns the stateless `/notify` endpoint or, if a key is
+// given, the stateful `/notify/{key}` endpoint.
+func (t *Apprise) getNotifyURL(key string) string {
+	if key == "" {
+		return t.url + "/notify/"
+	}
+	return t.url + "/notify/" + url.PathEscape(key)
+}
+
+// newRequest returns the request for the notification, which is sent as
+// multipart form if the message carries attachment data and as JSON
+// otherwise.
+func newRequest(
+	ctx context.Context,
+	notifyURL string,
+	n notification,
+	m message.Message,
+) (*http.Request, error) {
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil {
+		return nil, target.Permanent(err)
+	}
+
+	if data == nil {
+		n.Attach = m.GetAttachmentURL()
+		body, err := json.Marshal(n)
+		if err != nil {
+			return nil, err
+		}
+
+		req, err := http.NewRequestWithContext(ctx, "POST", notifyURL,
+			bytes.NewReader(body))
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Content-Type", "application/json")
+		return req, nil
+	}
+
+	var buf bytes.Buffer
+	w := multipart.NewWriter(&buf)
+	for _, field := range [][2]string{
+		{"urls", n.URLs},
+		{"tag", n.Tag},
+		{"title", n.Title},
+		{"body", n.Body},
+		{"type", n.Type},
+		{"format", n.Format},
+	} {
+		if field[1] == "" {
+			continue
+		}
+		if err := w.WriteField(field[0], field[1]); err != nil {
+			return nil, err
+		}
+	}
+
+	h := make(textproto.MIMEHeader)
+	h.Set("Content-Disposition", fmt.Sprintf(
+		`form-data; name="attach"; filename="%s"`,
+		m.GetAttachmentFilename(mimeType)))
+	h.Set("Content-Type", mimeType)
+	part, err := w.CreatePart(h)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := part.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", notifyURL, &buf)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+	return req, nil
+}
+
+// classify returns the error for an unsuccessful response of the Apprise API,
+// which is permanent for client errors other than rate limits.
+func classify(resp *http.Response) error {
+	var rerr struct {
+		Error string `json:"error"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(respBody, &rerr) != nil || rerr.Error == "" {
+		rerr.Error = strings.TrimSpace(string(respBody))
+	}
+
+	err := &APIError{StatusCode: resp.StatusCode, Message: rerr.Error}
+	switch {
+	case resp.StatusCode == http.StatusNoContent:
+		return target.Permanent(fmt.Errorf("%w: %w", ErrNoConfiguration, err))
+	case resp.StatusCode == http.StatusFailedDependency:
+		return fmt.Errorf("%w: %w", ErrNotificationFailed, err)
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return target.RetryAfter(err, time.Duration(retryAfter)*time.Second)
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499:
+		return target.Permanent(err)
+	}
+	return err
+}
+
+func (t *Apprise) executeAPI(
+	m message.Message,
+	connection string,
+	key string,
+	tag string,
+	title string,
+	body string,
+) error {
+	if key == "" && connection == "" {
+		return target.Permanent(
+			errors.New("Apprise API requires either a connection or a key"))
+	}
+
+	n := notification{
+		Tag:    tag,
+		Title:  title,
+		Body:   body,
+		Type:   notificationType(m.Priority),
+		Format: "text",
+	}
+	// The stateful endpoint notifies the services of the stored configuration
+	if key == "" {
+		n.URLs = connection
+	}
+	if m.HTML == 1 {
+		n.Format = "html"
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	req, err := newRequest(ctx, t.getNotifyURL(key), n, m)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 &&
+		resp.StatusCode != http.StatusNoContent {
+		t.log.Debug("Apprise API successfully sent message",
+			zap.Int("Status", resp.StatusCode))
+		return nil
+	}
+
+	return classify(resp)
+}
@@ -1,10 +1,13 @@
 package apprise
 
 import (
-	"context"
 	"errors"
-	"os"
-	"os/exec"
+	"fmt"
+	"html"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/mrusme/overpush/config"
@@ -14,10 +17,22 @@ import (
 	"go.uber.org/zap"
 )
 
+const (
+	DEFAULT_APPRISE     = "apprise"
+	DEFAULT_INTERPRETER = "python"
+	DEFAULT_TIMEOUT     = 30
+)
+
 type Apprise struct {
 	cfg       *config.Config
 	log       *zap.Logger
 	targetCfg target.Target
+
+	apprise     string
+	interpreter string
+	url         string
+	timeout     time.Duration
+	client      *http.Client
 }
 
 func New(
@@ -36,6 +51,39 @@ func New(
 
 func (t *Apprise) Load() error {
 	t.log.Info("Load target: Apprise")
+
+	// With a URL, notifications are sent via the Apprise API instead of the CLI
+	if val, ok := helpers.GetArg(t.targetCfg.Args, "url"); ok && val != "" {
+		u, err := url.Parse(val)
+		if err != nil || (u.Scheme != "http" && u.Scheme != "https") {
+			return errors.New("Apprise API URL must be an HTTP(S) URL")
+		}
+		t.url = strings.TrimRight(val, "/")
+	}
+
+	// The Apprise script is run using the interpreter, unless no script is
+	// configured, in which case the Apprise executable from the PATH is run.
+	// An empty interpreter runs the script as executable.
+	t.apprise = DEFAULT_APPRISE
+	t.interpreter = ""
+	if val, ok := helpers.GetArg(t.targetCfg.Args, "apprise"); ok && val != "" {
+		t.apprise = val
+		t.interpreter = DEFAULT_INTERPRETER
+	}
+	if val, ok := helpers.GetArg(t.targetCfg.Args, "interpreter"); ok {
+		t.interpreter = val
+	}
+
+	timeout := DEFAULT_TIMEOUT
+	if val, ok := helpers.GetArg(t.targetCfg.Args, "timeout"); ok {
+		var err error
+		if timeout, err = strconv.Atoi(val); err != nil || timeout <= 0 {
+			return errors.New("Could not parse Apprise timeout")
+		}
+	}
+	t.timeout = time.Duration(timeout) * time.Second
+	t.client = &http.Client{}
+
 	return nil
 }
 
@@ -44,6 +92,41 @@ func (t *Apprise) Run() error {
 	return nil
 }
 
+// notificationType returns the Apprise notification type for the priority.
+// Lower priorities are sent as `info` as well, as services display `success`
+// as a positive outcome (e.g. green or with a check mark), which the priority
+// does not imply.
+func notificationType(priority int) string {
+	switch {
+	case priority >= 2:
+		return "failure"
+	case priority == 1:
+		return "warning"
+	}
+	return "info"
+}
+
+// getBody returns the message body, including the message's URL.
+func getBody(m message.Message, prefix string) string {
+	body := prefix + m.Message
+	if m.URL == "" {
+		return body
+	}
+
+	if m.HTML == 1 {
+		label := m.URLTitle
+		if label == "" {
+			label = m.URL
+		}
+		return fmt.Sprintf("%s<br><br><a href=\"%s\">%s</a>", body,
+			html.EscapeString(m.URL), html.EscapeString(label))
+	}
+	if m.URLTitle != "" {
+		return fmt.Sprintf("%s\n\n%s: %s", body, m.URLTitle, m.URL)
+	}
+	return fmt.Sprintf("%s\n\n%s", body, m.URL)
+}
+
 func (t *Apprise) Execute(
 	m message.Message,
 	appArgs map[string]interface{},
@@ -58,7 +141,7 @@ func (t *Apprise) Execute(
 		if !ok {
 			return errors.New("Could not parse connection argument")
 		}
-	} else {
+	} else if t.url == "" {
 		return errors.New("Could not get connection string")
 	}
 
@@ -75,28 +158,22 @@ func (t *Apprise) Execute(
 		}
 	}
 
-	ctx, _ := context.WithTimeout(context.Background(), 10*time.Second)
-	cmd := exec.CommandContext(
-		ctx,
-		"python",
-		t.targetCfg.Args["apprise"].(string),
-		"-vv",
-		"-t", (prefix + m.Title),
-		"-b", (prefix + m.Message),
-		connection,
-	)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-
-	if err := cmd.Start(); err != nil {
-		return err
-	}
+	body := getBody(m, prefix)
 
-	if err := cmd.Wait(); err != nil {
-		return err
+	if t.url != "" {
+		var key string = ""
+		if val, ok := helpers.GetArg(t.targetCfg.Args, "key"); ok {
+			key, ok = helpers.GetFieldValue(val, appArgs)
+			if !ok {
+				return errors.New("Could not parse key argument")
+			}
+		}
+		tag, _ := helpers.GetArg(t.targetCfg.Args, "tag")
+
+		return t.executeAPI(m, connection, key, tag, (prefix + m.Title), body)
 	}
 
-	return nil
+	return t.executeCLI(m, connection, (prefix + m.Title), body)
 }
 
 func (t *Apprise) Shutdown() error {
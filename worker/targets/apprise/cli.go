@@ -0,0 +1,118 @@
+package apprise
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	// MAX_OUTPUT_LENGTH is the maximum length of the CLI output included in
+	// errors
+	MAX_OUTPUT_LENGTH = 2048
+)
+
+// writeAttachment writes the message's attachment data to a temporary file
+// and returns its path, which is empty if the message carries no data.
+func writeAttachment(m message.Message) (string, error) {
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil || data == nil {
+		return "", err
+	}
+
+	dir, err := os.MkdirTemp("", "overpush-apprise-")
+	if err != nil {
+		return "", err
+	}
+
+	// Apprise derives the attachment's name from the file name
+	path := filepath.Join(dir, filepath.Base(m.GetAttachmentFilename(mimeType)))
+	if err := os.WriteFile(path, data, 0600); err != nil {
+		os.RemoveAll(dir)
+		return "", err
+	}
+
+	return path, nil
+}
+
+func (t *Apprise) executeCLI(
+	m message.Message,
+	connection string,
+	title string,
+	body string,
+) error {
+	var format string = "text"
+	if m.HTML == 1 {
+		format = "html"
+	}
+
+	var args []string
+	if t.interpreter != "" {
+		args = append(args, t.apprise)
+	}
+	args = append(args,
+		"-vv",
+		"--notification-type", notificationType(m.Priority),
+		"--input-format", format,
+		"-t", title,
+		"-b", body,
+	)
+
+	path, err := writeAttachment(m)
+	if err != nil {
+		return target.Permanent(err)
+	}
+	if path != "" {
+		defer os.RemoveAll(filepath.Dir(path))
+		args = append(args, "--attach", path)
+	} else if u := m.GetAttachmentURL(); u != "" {
+		args = append(args, "--attach", u)
+	}
+	// The connection is never parsed as option, even if it starts with "-"
+	args = append(args, "--", connection)
+
+	name := t.apprise
+	if t.interpreter != "" {
+		name = t.interpreter
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), t.timeout)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, name, args...)
+	// Child processes might keep the output open after Apprise was killed
+	cmd.WaitDelay = time.Second
+
+	var output bytes.Buffer
+	cmd.Stdout = &output
+	cmd.Stderr = &output
+
+	if err := cmd.Run(); err != nil {
+		if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %s: %w", t.timeout, err)
+		}
+		out := strings.TrimSpace(output.String())
+		if out == "" {
+			return fmt.Errorf("Apprise failed: %w", err)
+		}
+		// The end of the output contains the reason of the failure
+		if len(out) > MAX_OUTPUT_LENGTH {
+			out = "…" + out[len(out)-MAX_OUTPUT_LENGTH:]
+		}
+		return fmt.Errorf("Apprise failed: %w: %s", err, out)
+	}
+
+	t.log.Debug("Apprise successfully sent message",
+		zap.String("Output", helpers.Truncate(output.String(), MAX_OUTPUT_LENGTH)))
+	return nil
+}
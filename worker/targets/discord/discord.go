@@ -0,0 +1,238 @@
+package discord
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT = 30 * time.Second
+
+	MAX_TITLE_LENGTH       = 256
+	MAX_DESCRIPTION_LENGTH = 4096
+	MAX_LABEL_LENGTH       = 80
+	// MIN_RETRY_AFTER is the delay used for rate limits that do not specify
+	// a (positive) `retry_after`
+	MIN_RETRY_AFTER = 1 * time.Second
+)
+
+type Discord struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	client *http.Client
+}
+
+type embedImage struct {
+	URL string `json:"url"`
+}
+
+type embed struct {
+	Title       string      `json:"title,omitempty"`
+	Description string      `json:"description,omitempty"`
+	URL         string      `json:"url,omitempty"`
+	Color       int         `json:"color"`
+	Timestamp   string      `json:"timestamp"`
+	Image       *embedImage `json:"image,omitempty"`
+}
+
+type component struct {
+	Type       int         `json:"type"`
+	Style      int         `json:"style,omitempty"`
+	Label      string      `json:"label,omitempty"`
+	URL        string      `json:"url,omitempty"`
+	Components []component `json:"components,omitempty"`
+}
+
+type payload struct {
+	Username   string      `json:"username,omitempty"`
+	AvatarURL  string      `json:"avatar_url,omitempty"`
+	Embeds     []embed     `json:"embeds"`
+	Components []component `json:"components,omitempty"`
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*Discord, error) {
+	t := new(Discord)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *Discord) Load() error {
+	t.log.Info("Load target: Discord")
+
+	if _, ok := helpers.GetArg(t.targetCfg.Args, "webhook"); !ok {
+		return errors.New("Could not get Discord webhook")
+	}
+
+	t.client = &http.Client{Timeout: TIMEOUT}
+
+	return nil
+}
+
+func (t *Discord) Run() error {
+	t.log.Info("Run target: Discord")
+	return nil
+}
+
+func (t *Discord) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	webhookTmpl, _ := helpers.GetArg(t.targetCfg.Args, "webhook")
+	webhook, ok := helpers.GetFieldValue(webhookTmpl, appArgs)
+	if !ok || webhook == "" {
+		return errors.New("Could not parse webhook argument")
+	}
+
+	em := embed{
+		Title:       helpers.Truncate(m.Title, MAX_TITLE_LENGTH),
+		Description: helpers.Truncate(m.Message, MAX_DESCRIPTION_LENGTH),
+		URL:         m.URL,
+		Color:       m.GetPriorityColor(),
+		Timestamp:   m.GetTime().UTC().Format(time.RFC3339),
+	}
+
+	pl := payload{}
+	pl.Username, _ = helpers.GetArg(t.targetCfg.Args, "username")
+	pl.AvatarURL, _ = helpers.GetArg(t.targetCfg.Args, "avatarurl")
+
+	if m.URL != "" {
+		label := m.URLTitle
+		if label == "" {
+			label = "Open"
+		}
+		// Link buttons (style 5) inside of an action row (type 1)
+		pl.Components = []component{{
+			Type: 1,
+			Components: []component{{
+				Type:  2,
+				Style: 5,
+				Label: helpers.Truncate(label, MAX_LABEL_LENGTH),
+				URL:   m.URL,
+			}},
+		}}
+	}
+
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil {
+		return err
+	}
+	filename := m.GetAttachmentFilename(mimeType)
+	if data != nil && strings.HasPrefix(mimeType, "image/") {
+		em.Image = &embedImage{URL: "attachment://" + filename}
+	} else if u := m.GetAttachmentURL(); u != "" {
+		em.Image = &embedImage{URL: u}
+	}
+	pl.Embeds = []embed{em}
+
+	body, err := json.Marshal(pl)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(webhook)
+	if err != nil {
+		return err
+	}
+	q := u.Query()
+	q.Set("with_components", "true")
+	u.RawQuery = q.Encode()
+
+	var req *http.Request
+	if data == nil {
+		req, err = http.NewRequest("POST", u.String(), bytes.NewReader(body))
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", "application/json")
+	} else {
+		var buf bytes.Buffer
+		w := multipart.NewWriter(&buf)
+		if err := w.WriteField("payload_json", string(body)); err != nil {
+			return err
+		}
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Disposition",
+			fmt.Sprintf(`form-data; name="files[0]"; filename="%s"`, filename))
+		h.Set("Content-Type", mimeType)
+		fw, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+		if err := w.Close(); err != nil {
+			return err
+		}
+
+		req, err = http.NewRequest("POST", u.String(), &buf)
+		if err != nil {
+			return err
+		}
+		req.Header.Set("Content-Type", w.FormDataContentType())
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// Note: The error contains the webhook URL, which contains its token
+		t.log.Error("Discord failed to send")
+		return errors.New("Discord webhook request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		t.log.Debug("Discord successfully sent message")
+		return nil
+	}
+
+	var derr struct {
+		Message    string  `json:"message"`
+		RetryAfter float64 `json:"retry_after"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	json.Unmarshal(respBody, &derr)
+	if derr.Message == "" {
+		derr.Message = strings.TrimSpace(string(respBody))
+	}
+
+	err = fmt.Errorf("Discord returned %s: %s", resp.Status, derr.Message)
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		return target.RetryAfter(err, max(
+			time.Duration(derr.RetryAfter*float64(time.Second)),
+			MIN_RETRY_AFTER))
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499:
+		return target.Permanent(err)
+	}
+	return err
+}
+
+func (t *Discord) Shutdown() error {
+	t.log.Info("Shutdown target: Discord")
+	return nil
+}
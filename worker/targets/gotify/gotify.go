@@ -0,0 +1,174 @@
+package gotify
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT = 30 * time.Second
+)
+
+type Gotify struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	server   string
+	token    string
+	markdown bool
+	client   *http.Client
+}
+
+type gotifyMessage struct {
+	Title    string                 `json:"title,omitempty"`
+	Message  string                 `json:"message"`
+	Priority int                    `json:"priority"`
+	Extras   map[string]interface{} `json:"extras,omitempty"`
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*Gotify, error) {
+	t := new(Gotify)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *Gotify) Load() error {
+	t.log.Info("Load target: Gotify")
+
+	server, ok := helpers.GetArg(t.targetCfg.Args, "server")
+	if !ok || server == "" {
+		return errors.New("Could not get Gotify server")
+	}
+	t.server = strings.TrimSuffix(server, "/")
+
+	t.token, ok = helpers.GetArg(t.targetCfg.Args, "token")
+	if !ok || t.token == "" {
+		return errors.New("Could not get Gotify application token")
+	}
+
+	if markdown, ok := helpers.GetArg(t.targetCfg.Args, "markdown"); ok {
+		t.markdown, _ = strconv.ParseBool(markdown)
+	}
+
+	t.client = &http.Client{Timeout: TIMEOUT}
+
+	return nil
+}
+
+func (t *Gotify) Run() error {
+	t.log.Info("Run target: Gotify")
+	return nil
+}
+
+// priority maps Pushover priorities (-2 to 2) to Gotify priorities (0 to 10)
+func priority(p int) int {
+	switch {
+	case p <= -2:
+		return 0
+	case p == -1:
+		return 2
+	case p == 1:
+		return 8
+	case p >= 2:
+		return 10
+	}
+	return 5
+}
+
+func (t *Gotify) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	gm := gotifyMessage{
+		Title:    m.Title,
+		Message:  m.Message,
+		Priority: priority(m.Priority),
+		Extras:   make(map[string]interface{}),
+	}
+
+	// Gotify renders markdown, which includes inline HTML
+	markdown := t.markdown == true || m.HTML == 1
+	if markdown == true {
+		gm.Extras["client::display"] = map[string]interface{}{
+			"contentType": "text/markdown",
+		}
+	}
+
+	notification := make(map[string]interface{})
+	if m.URL != "" {
+		notification["click"] = map[string]interface{}{"url": m.URL}
+		if markdown == true {
+			title := m.URLTitle
+			if title == "" {
+				title = m.URL
+			}
+			gm.Message = fmt.Sprintf("%s\n\n[%s](%s)", gm.Message, title, m.URL)
+		} else {
+			gm.Message = fmt.Sprintf("%s\n\n%s", gm.Message,
+				strings.TrimSpace(m.URLTitle+" "+m.URL))
+		}
+	}
+	if u := m.GetAttachmentURL(); u != "" {
+		notification["bigImageUrl"] = u
+	}
+	if len(notification) > 0 {
+		gm.Extras["client::notification"] = notification
+	}
+
+	body, err := json.Marshal(gm)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", t.server+"/message", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-Gotify-Key", t.token)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		t.log.Error("Gotify failed to send",
+			zap.Error(err))
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("Gotify returned %s: %s",
+			resp.Status, strings.TrimSpace(string(respBody)))
+	}
+
+	t.log.Debug("Gotify successfully sent message")
+
+	return nil
+}
+
+func (t *Gotify) Shutdown() error {
+	t.log.Info("Shutdown target: Gotify")
+	return nil
+}
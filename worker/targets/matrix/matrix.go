@@ -0,0 +1,550 @@
+package matrix
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"github.com/mrusme/overpush/worker/targets/acknowledge"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT = 30 * time.Second
+	// SYNC_TIMEOUT is the time the homeserver holds a sync request open
+	SYNC_TIMEOUT = 30 * time.Second
+	// MAX_RECONNECT_DELAY caps the delay between failed sync attempts
+	MAX_RECONNECT_DELAY = 5 * time.Minute
+)
+
+type Matrix struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	homeserver  string
+	accessToken string
+	userID      string
+	client      *http.Client
+
+	encrypted   map[string]bool
+	encryptedMu sync.Mutex
+	rooms       map[string]string
+	roomsMu     sync.Mutex
+	txn         atomic.Int64
+
+	ack *acknowledge.Acknowledger
+
+	cancel context.CancelFunc
+	done   chan struct{}
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*Matrix, error) {
+	t := new(Matrix)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+	t.encrypted = make(map[string]bool)
+	t.rooms = make(map[string]string)
+
+	return t, nil
+}
+
+func (t *Matrix) SetAcknowledger(ack *acknowledge.Acknowledger) {
+	t.ack = ack
+}
+
+func (t *Matrix) Load() error {
+	t.log.Info("Load target: Matrix")
+
+	homeserver, ok := helpers.GetArg(t.targetCfg.Args, "homeserver")
+	if !ok || homeserver == "" {
+		return errors.New("Could not get Matrix homeserver")
+	}
+	t.homeserver = strings.TrimSuffix(homeserver, "/")
+
+	t.accessToken, ok = helpers.GetArg(t.targetCfg.Args, "token")
+	if !ok || t.accessToken == "" {
+		return errors.New("Could not get Matrix access token")
+	}
+
+	t.client = &http.Client{Timeout: TIMEOUT + SYNC_TIMEOUT}
+
+	return nil
+}
+
+func (t *Matrix) Run() error {
+	t.log.Info("Run target: Matrix")
+
+	return t.reconnect()
+}
+
+// reconnect verifies the access token and (re)starts listening for replies.
+func (t *Matrix) reconnect() error {
+	if t.cancel != nil {
+		t.log.Debug("Matrix stop existing sync")
+		t.cancel()
+		<-t.done
+	}
+
+	var whoami struct {
+		UserID string `json:"user_id"`
+	}
+	if err := t.request(
+		context.Background(),
+		"GET", "/_matrix/client/v3/account/whoami",
+		nil, &whoami,
+	); err != nil {
+		t.log.Error("Matrix failed to connect",
+			zap.Error(err))
+		return err
+	}
+	t.userID = whoami.UserID
+
+	ctx, cancel := context.WithCancel(context.Background())
+	t.cancel = cancel
+	t.done = make(chan struct{})
+	go t.listen(ctx, t.done)
+
+	return nil
+}
+
+// request performs a client-server API request and decodes the response into
+// `out`, if given.
+func (t *Matrix) request(
+	ctx context.Context,
+	method string,
+	path string,
+	body interface{},
+	out interface{},
+) error {
+	var reader io.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			return err
+		}
+		reader = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, t.homeserver+path, reader)
+	if err != nil {
+		return err
+	}
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	return t.do(req, out)
+}
+
+func (t *Matrix) do(req *http.Request, out interface{}) error {
+	req.Header.Set("Authorization", "Bearer "+t.accessToken)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		var merr struct {
+			ErrCode string `json:"errcode"`
+			Error   string `json:"error"`
+		}
+		data, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+		if json.Unmarshal(data, &merr) == nil && merr.ErrCode != "" {
+			return fmt.Errorf("Matrix returned %s: %s: %s",
+				resp.Status, merr.ErrCode, merr.Error)
+		}
+		return fmt.Errorf("Matrix returned %s", resp.Status)
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+type syncResponse struct {
+	NextBatch string `json:"next_batch"`
+	Rooms     struct {
+		Join map[string]struct {
+			Timeline struct {
+				Events []struct {
+					Type    string `json:"type"`
+					Sender  string `json:"sender"`
+					Content struct {
+						MsgType string `json:"msgtype"`
+						Body    string `json:"body"`
+					} `json:"content"`
+				} `json:"events"`
+			} `json:"timeline"`
+		} `json:"join"`
+	} `json:"rooms"`
+}
+
+const syncFilter = `{"presence":{"types":[]},"account_data":{"types":[]},` +
+	`"room":{"state":{"types":[]},"ephemeral":{"types":[]},` +
+	`"account_data":{"types":[]},` +
+	`"timeline":{"limit":20,"types":["m.room.message"]}}}`
+
+// listen long-polls the homeserver for new messages until the context is
+// cancelled, in order to process acknowledgements of emergency messages.
+// Failed syncs are retried with an increasing delay.
+func (t *Matrix) listen(ctx context.Context, done chan struct{}) {
+	defer close(done)
+
+	var since string
+	var delay time.Duration = 0
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(delay):
+		}
+
+		params := url.Values{}
+		params.Set("filter", syncFilter)
+		if since != "" {
+			params.Set("since", since)
+			params.Set("timeout", strconv.FormatInt(SYNC_TIMEOUT.Milliseconds(), 10))
+		}
+
+		var resp syncResponse
+		if err := t.request(
+			ctx,
+			"GET", "/_matrix/client/v3/sync?"+params.Encode(),
+			nil, &resp,
+		); err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			delay = min(max(2*delay, 5*time.Second), MAX_RECONNECT_DELAY)
+			t.log.Debug("Matrix sync failed, retrying ...",
+				zap.Duration("In", delay),
+				zap.Error(err))
+			continue
+		}
+		delay = 0
+
+		// The initial sync only determines where to continue from, so that old
+		// messages are not being processed again
+		if since != "" {
+			for roomID, room := range resp.Rooms.Join {
+				for _, ev := range room.Timeline.Events {
+					if ev.Type != "m.room.message" || ev.Sender == t.userID ||
+						ev.Content.MsgType != "m.text" {
+						continue
+					}
+					t.handleReply(ctx, roomID, ev.Sender, ev.Content.Body)
+				}
+			}
+		}
+		since = resp.NextBatch
+	}
+}
+
+// handleReply acknowledges pending emergency messages of the room when someone
+// in it replies with an acknowledgement.
+func (t *Matrix) handleReply(
+	ctx context.Context,
+	roomID string,
+	sender string,
+	text string,
+) {
+	if t.ack == nil {
+		return
+	}
+
+	if text, ok := t.ack.HandleReply(
+		"matrix:"+roomID,
+		"matrix:"+sender,
+		text,
+	); ok {
+		t.reply(ctx, roomID, text)
+	}
+}
+
+func (t *Matrix) reply(ctx context.Context, roomID string, text string) {
+	if err := t.send(ctx, roomID, "", map[string]interface{}{
+		"msgtype": "m.notice",
+		"body":    text,
+	}); err != nil {
+		t.log.Error("Matrix failed to reply",
+			zap.Error(err))
+	}
+}
+
+// send sends the event content to the room. Events that belong to a delivery
+// use a transaction ID derived from the delivery ID (see
+// message.GetDeliveryID) and the part of the message they are, so that the
+// homeserver deduplicates them when the delivery is retried.
+func (t *Matrix) send(
+	ctx context.Context,
+	roomID string,
+	txnID string,
+	content map[string]interface{},
+) error {
+	if txnID == "" {
+		txnID = fmt.Sprintf("overpush-%d-%d", time.Now().UnixNano(), t.txn.Add(1))
+	}
+	return t.request(ctx, "PUT",
+		"/_matrix/client/v3/rooms/"+url.PathEscape(roomID)+
+			"/send/m.room.message/"+url.PathEscape(txnID),
+		content, nil)
+}
+
+// txnID returns the transaction ID for the given part of the message, or an
+// empty string if the message has no delivery ID.
+func txnID(m message.Message, part int) string {
+	deliveryID := m.GetDeliveryID()
+	if deliveryID == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(deliveryID))
+	return fmt.Sprintf("overpush-%s-%d", hex.EncodeToString(sum[:16]), part)
+}
+
+// resolveRoom returns the room ID for room aliases (e.g. "#room:matrix.org").
+func (t *Matrix) resolveRoom(ctx context.Context, room string) (string, error) {
+	if strings.HasPrefix(room, "#") == false {
+		return room, nil
+	}
+
+	t.roomsMu.Lock()
+	roomID, ok := t.rooms[room]
+	t.roomsMu.Unlock()
+	if ok {
+		return roomID, nil
+	}
+
+	var resp struct {
+		RoomID string `json:"room_id"`
+	}
+	if err := t.request(ctx, "GET",
+		"/_matrix/client/v3/directory/room/"+url.PathEscape(room),
+		nil, &resp); err != nil {
+		return "", err
+	}
+
+	t.roomsMu.Lock()
+	t.rooms[room] = resp.RoomID
+	t.roomsMu.Unlock()
+	return resp.RoomID, nil
+}
+
+// isEncrypted reports whether end-to-end encryption is enabled for the room.
+func (t *Matrix) isEncrypted(ctx context.Context, roomID string) (bool, error) {
+	t.encryptedMu.Lock()
+	encrypted, ok := t.encrypted[roomID]
+	t.encryptedMu.Unlock()
+	if ok {
+		return encrypted, nil
+	}
+
+	err := t.request(ctx, "GET",
+		"/_matrix/client/v3/rooms/"+url.PathEscape(roomID)+
+			"/state/m.room.encryption/",
+		nil, nil)
+	if err == nil {
+		encrypted = true
+	} else if strings.Contains(err.Error(), "M_NOT_FOUND") {
+		encrypted = false
+	} else {
+		return false, err
+	}
+
+	// Note: Encryption cannot be disabled once it was enabled for a room
+	t.encryptedMu.Lock()
+	t.encrypted[roomID] = encrypted
+	t.encryptedMu.Unlock()
+	return encrypted, nil
+}
+
+// upload uploads the data to the media repository and returns its MXC URI.
+func (t *Matrix) upload(
+	ctx context.Context,
+	data []byte,
+	mimeType string,
+	filename string,
+) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "POST",
+		t.homeserver+"/_matrix/media/v3/upload?filename="+
+			url.QueryEscape(filename),
+		bytes.NewReader(data))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", mimeType)
+
+	var resp struct {
+		ContentURI string `json:"content_uri"`
+	}
+	if err := t.do(req, &resp); err != nil {
+		return "", err
+	}
+
+	return resp.ContentURI, nil
+}
+
+func (t *Matrix) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	room, ok := helpers.GetArg(appArgs, "room")
+	if !ok || room == "" {
+		room, ok = helpers.GetArg(appArgs, "destination")
+	}
+	if !ok || room == "" {
+		return errors.New("Could not get Matrix room")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	defer cancel()
+
+	roomID, err := t.resolveRoom(ctx, room)
+	if err != nil {
+		return err
+	}
+
+	// Note: End-to-end encryption is not supported, hence messages are never
+	// sent to encrypted rooms, where they would appear unencrypted.
+	encrypted, err := t.isEncrypted(ctx, roomID)
+	if err != nil {
+		return err
+	}
+	if encrypted == true {
+		return target.Permanent(errors.New(
+			"Matrix room is end-to-end encrypted, which is not supported"))
+	}
+
+	plain := m.ToString()
+	var formatted string
+	if m.Title != "" {
+		formatted = "<strong>" + html.EscapeString(m.Title) + "</strong><br>"
+	}
+	if m.HTML == 1 {
+		formatted += m.Message
+	} else {
+		formatted += strings.ReplaceAll(html.EscapeString(m.Message), "\n", "<br>")
+	}
+	if m.URL != "" {
+		title := m.URLTitle
+		if title == "" {
+			title = m.URL
+		}
+		formatted += fmt.Sprintf("<br><a href=\"%s\">%s</a>",
+			html.EscapeString(m.URL), html.EscapeString(title))
+	}
+	if m.GetReceipt() != "" && t.ack != nil {
+		ack := acknowledge.Instructions(m.GetReceipt())
+		plain = fmt.Sprintf("%s\n%s", plain, ack)
+		formatted = fmt.Sprintf("%s<br><em>%s</em>", formatted,
+			html.EscapeString(ack))
+	}
+
+	if err := t.send(ctx, roomID, txnID(m, 0), map[string]interface{}{
+		"msgtype":        "m.text",
+		"body":           plain,
+		"format":         "org.matrix.custom.html",
+		"formatted_body": formatted,
+	}); err != nil {
+		t.log.Error("Matrix failed to send",
+			zap.Error(err))
+		return err
+	}
+
+	if err := t.sendAttachment(ctx, roomID, m); err != nil {
+		t.log.Error("Matrix failed to send attachment",
+			zap.Error(err))
+		return err
+	}
+
+	if m.GetReceipt() != "" && t.ack != nil {
+		t.ack.AddPending("matrix:"+roomID, m.GetReceipt())
+	}
+
+	t.log.Debug("Matrix successfully sent message",
+		zap.String("roomID", roomID))
+
+	return nil
+}
+
+// sendAttachment uploads the message's attachment and sends it as image or
+// file to the room.
+func (t *Matrix) sendAttachment(
+	ctx context.Context,
+	roomID string,
+	m message.Message,
+) error {
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil {
+		return err
+	}
+
+	filename := m.GetAttachmentFilename(mimeType)
+	if data == nil {
+		if u := m.GetAttachmentURL(); u != "" {
+			return t.send(ctx, roomID, txnID(m, 1), map[string]interface{}{
+				"msgtype": "m.text",
+				"body":    u,
+			})
+		}
+		return nil
+	}
+
+	uri, err := t.upload(ctx, data, mimeType, filename)
+	if err != nil {
+		return err
+	}
+
+	msgtype := "m.file"
+	if strings.HasPrefix(mimeType, "image/") {
+		msgtype = "m.image"
+	}
+
+	return t.send(ctx, roomID, txnID(m, 1), map[string]interface{}{
+		"msgtype": msgtype,
+		"body":    filename,
+		"url":     uri,
+		"info": map[string]interface{}{
+			"mimetype": mimeType,
+			"size":     len(data),
+		},
+	})
+}
+
+func (t *Matrix) Shutdown() error {
+	t.log.Info("Shutdown target: Matrix")
+
+	if t.cancel != nil {
+		t.cancel()
+		<-t.done
+	}
+
+	return nil
+}
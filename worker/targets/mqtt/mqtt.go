@@ -0,0 +1,268 @@
+package mqtt
+
+import (
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	paho "github.com/eclipse/paho.mqtt.golang"
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT            = 30 * time.Second
+	DEFAULT_KEEP_ALIVE = 60 * time.Second
+	DEFAULT_TOPIC      = `overpush/{{ arg "destination" }}`
+	DEFAULT_QOS        = 1
+	// MAX_RECONNECT_INTERVAL is the maximum delay between attempts to
+	// (re)connect to the broker
+	MAX_RECONNECT_INTERVAL = 1 * time.Minute
+)
+
+// prioritySuffixes are appended to the topic, for priorities -2 to 2
+var prioritySuffixes = []string{"lowest", "low", "normal", "high", "emergency"}
+
+type MQTT struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	opts           *paho.ClientOptions
+	topic          string
+	qos            byte
+	retain         bool
+	prioritySuffix bool
+
+	client paho.Client
+}
+
+type payload struct {
+	Title      string   `json:"title"`
+	Message    string   `json:"message"`
+	HTML       bool     `json:"html"`
+	Priority   int      `json:"priority"`
+	Timestamp  int64    `json:"timestamp"`
+	URL        string   `json:"url,omitempty"`
+	URLTitle   string   `json:"url_title,omitempty"`
+	Attachment string   `json:"attachment,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Receipt    string   `json:"receipt,omitempty"`
+
+	AttachmentBase64 string `json:"attachment_base64,omitempty"`
+	AttachmentType   string `json:"attachment_type,omitempty"`
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*MQTT, error) {
+	t := new(MQTT)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *MQTT) Load() error {
+	t.log.Info("Load target: MQTT")
+
+	broker, ok := helpers.GetArg(t.targetCfg.Args, "broker")
+	if !ok || broker == "" {
+		return errors.New("Could not get MQTT broker")
+	}
+	u, err := url.Parse(broker)
+	if err != nil {
+		return err
+	}
+
+	t.opts = paho.NewClientOptions()
+
+	port := u.Port()
+	switch u.Scheme {
+	case "mqtt", "tcp":
+		if port == "" {
+			port = "1883"
+		}
+		t.opts.AddBroker("tcp://" + net.JoinHostPort(u.Hostname(), port))
+	case "mqtts", "ssl", "tls":
+		if port == "" {
+			port = "8883"
+		}
+		t.opts.AddBroker("ssl://" + net.JoinHostPort(u.Hostname(), port))
+		t.opts.SetTLSConfig(&tls.Config{ServerName: u.Hostname()})
+	default:
+		return errors.New("MQTT broker must be a mqtt:// or mqtts:// URL")
+	}
+
+	// With a fixed client ID, the broker keeps the session (e.g. the state of
+	// QoS 2 deliveries) across reconnects
+	clientID, _ := helpers.GetArg(t.targetCfg.Args, "clientid")
+	t.opts.SetCleanSession(clientID == "")
+	if clientID == "" {
+		b := make([]byte, 4)
+		rand.Read(b)
+		clientID = "overpush-" + hex.EncodeToString(b)
+	}
+	t.opts.SetClientID(clientID)
+
+	username, _ := helpers.GetArg(t.targetCfg.Args, "username")
+	password, _ := helpers.GetArg(t.targetCfg.Args, "password")
+	t.opts.SetUsername(username)
+	t.opts.SetPassword(password)
+
+	t.opts.SetKeepAlive(DEFAULT_KEEP_ALIVE)
+	t.opts.SetConnectTimeout(TIMEOUT)
+	t.opts.SetWriteTimeout(TIMEOUT)
+	t.opts.SetAutoReconnect(true)
+	t.opts.SetConnectRetry(true)
+	t.opts.SetMaxReconnectInterval(MAX_RECONNECT_INTERVAL)
+	t.opts.SetOnConnectHandler(func(paho.Client) {
+		t.log.Debug("MQTT connected to broker")
+	})
+	t.opts.SetConnectionLostHandler(func(_ paho.Client, err error) {
+		t.log.Error("MQTT connection lost, reconnecting ...",
+			zap.Error(err))
+	})
+
+	t.topic = DEFAULT_TOPIC
+	if topic, ok := helpers.GetArg(t.targetCfg.Args, "topic"); ok && topic != "" {
+		t.topic = topic
+	}
+
+	t.qos = DEFAULT_QOS
+	if val, ok := helpers.GetArg(t.targetCfg.Args, "qos"); ok {
+		qos, err := strconv.Atoi(val)
+		if err != nil || qos < 0 || qos > 2 {
+			return errors.New("MQTT QoS must be 0, 1 or 2")
+		}
+		t.qos = byte(qos)
+	}
+
+	if val, ok := helpers.GetArg(t.targetCfg.Args, "retain"); ok {
+		t.retain, _ = strconv.ParseBool(val)
+	}
+
+	t.prioritySuffix = true
+	if val, ok := helpers.GetArg(t.targetCfg.Args, "prioritysuffix"); ok {
+		t.prioritySuffix, _ = strconv.ParseBool(val)
+	}
+
+	return nil
+}
+
+func (t *MQTT) Run() error {
+	t.log.Info("Run target: MQTT")
+
+	// The client keeps retrying to connect in the background, so that an
+	// unreachable broker does not prevent the worker from starting
+	t.client = paho.NewClient(t.opts)
+	t.client.Connect()
+
+	return nil
+}
+
+func (t *MQTT) getTopic(
+	m message.Message,
+	appArgs map[string]interface{},
+) (string, error) {
+	topic, err := helpers.RenderTemplate(t.topic, appArgs, m)
+	if err != nil {
+		return "", err
+	}
+	topic = strings.TrimSuffix(topic, "/")
+
+	if t.prioritySuffix == true {
+		priority := min(max(m.Priority, -2), 2)
+		topic = topic + "/" + prioritySuffixes[priority+2]
+	}
+
+	if topic == "" || strings.ContainsAny(topic, "+#") {
+		return "", errors.New("MQTT topic must not be empty or contain wildcards")
+	}
+
+	return topic, nil
+}
+
+func (t *MQTT) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	topic, err := t.getTopic(m, appArgs)
+	if err != nil {
+		return target.Permanent(err)
+	}
+
+	p := payload{
+		Title:      m.Title,
+		Message:    m.Message,
+		HTML:       m.HTML == 1,
+		Priority:   m.Priority,
+		Timestamp:  m.GetTime().Unix(),
+		URL:        m.URL,
+		URLTitle:   m.URLTitle,
+		Attachment: m.GetAttachmentURL(),
+		Tags:       m.GetTags(),
+		Receipt:    m.GetReceipt(),
+	}
+
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil {
+		return err
+	}
+	if data != nil {
+		p.AttachmentBase64 = base64.StdEncoding.EncodeToString(data)
+		p.AttachmentType = mimeType
+	}
+
+	body, err := json.Marshal(p)
+	if err != nil {
+		return err
+	}
+
+	if t.client == nil {
+		return errors.New("MQTT client is not running")
+	}
+
+	// Publishes that are in flight when the connection drops are resent once
+	// the client has reconnected
+	token := t.client.Publish(topic, t.qos, t.retain, body)
+	if token.WaitTimeout(TIMEOUT) == false {
+		t.log.Error("MQTT publish timed out")
+		return errors.New("MQTT publish timed out")
+	}
+	if err := token.Error(); err != nil {
+		t.log.Error("MQTT failed to publish",
+			zap.Error(err))
+		return err
+	}
+
+	t.log.Debug("MQTT successfully published message",
+		zap.String("Topic", topic))
+
+	return nil
+}
+
+func (t *MQTT) Shutdown() error {
+	t.log.Info("Shutdown target: MQTT")
+
+	if t.client != nil {
+		t.client.Disconnect(uint(TIMEOUT / time.Millisecond))
+	}
+
+	return nil
+}
@@ -0,0 +1,180 @@
+package mqtt
+
+import (
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/eclipse/paho.mqtt.golang/packets"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+// broker is a stand-in for an MQTT broker, handling one connection at a time.
+type broker struct {
+	t    *testing.T
+	ln   net.Listener
+	conn net.Conn
+}
+
+func listen(t *testing.T, addr string) *broker {
+	t.Helper()
+
+	ln, err := net.Listen("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { ln.Close() })
+	return &broker{t: t, ln: ln}
+}
+
+// accept accepts the next connection of the client.
+func (b *broker) accept() {
+	b.t.Helper()
+
+	conn, err := b.ln.Accept()
+	if err != nil {
+		b.t.Fatal(err)
+	}
+	b.t.Cleanup(func() { conn.Close() })
+	b.conn = conn
+
+	b.expect(packets.Connect)
+	b.send(packets.NewControlPacket(packets.Connack))
+}
+
+// expect reads the next packet, skipping pings, and fails unless it is of the
+// wanted type.
+func (b *broker) expect(want byte) packets.ControlPacket {
+	b.t.Helper()
+
+	for {
+		b.conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+		cp, err := packets.ReadPacket(b.conn)
+		if err != nil {
+			b.t.Fatalf("broker failed to read packet: %v", err)
+		}
+		if _, ok := cp.(*packets.PingreqPacket); ok {
+			continue
+		}
+		if strings.HasPrefix(cp.String(), packets.PacketNames[want]) == false {
+			b.t.Fatalf("broker received %s, want %s",
+				cp.String(), packets.PacketNames[want])
+		}
+		return cp
+	}
+}
+
+func (b *broker) send(cp packets.ControlPacket) {
+	b.t.Helper()
+
+	if err := cp.Write(b.conn); err != nil {
+		b.t.Fatalf("broker failed to write packet: %v", err)
+	}
+}
+
+func newMQTT(t *testing.T, addr string, qos string) *MQTT {
+	t.Helper()
+
+	m, err := New(nil, zap.NewNop(), target.Target{
+		Enable: true,
+		ID:     "mqtt",
+		Type:   "mqtt",
+		Args: map[string]interface{}{
+			"broker":   "mqtt://" + addr,
+			"clientid": "overpush-test",
+			"qos":      qos,
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := m.Load(); err != nil {
+		t.Fatal(err)
+	}
+	m.opts.SetConnectRetryInterval(50 * time.Millisecond)
+	m.opts.SetMaxReconnectInterval(100 * time.Millisecond)
+
+	if err := m.Run(); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { m.Shutdown() })
+	return m
+}
+
+func execute(m *MQTT) <-chan error {
+	errs := make(chan error, 1)
+	go func() {
+		errs <- m.Execute(message.Message{Message: "hello"},
+			map[string]interface{}{"destination": "alerts"})
+	}()
+	return errs
+}
+
+func TestExecuteQoS2(t *testing.T) {
+	b := listen(t, "127.0.0.1:0")
+	m := newMQTT(t, b.ln.Addr().String(), "2")
+	b.accept()
+
+	errs := execute(m)
+
+	pub := b.expect(packets.Publish).(*packets.PublishPacket)
+	if pub.Qos != 2 || pub.TopicName != "overpush/alerts/normal" ||
+		strings.Contains(string(pub.Payload), `"message":"hello"`) == false {
+		t.Fatalf("broker received %s %q", pub.String(), pub.Payload)
+	}
+
+	pubrec := packets.NewControlPacket(packets.Pubrec).(*packets.PubrecPacket)
+	pubrec.MessageID = pub.MessageID
+	b.send(pubrec)
+
+	pubrel := b.expect(packets.Pubrel).(*packets.PubrelPacket)
+	if pubrel.MessageID != pub.MessageID {
+		t.Fatalf("PUBREL has ID %d, want %d", pubrel.MessageID, pub.MessageID)
+	}
+
+	pubcomp := packets.NewControlPacket(packets.Pubcomp).(*packets.PubcompPacket)
+	pubcomp.MessageID = pub.MessageID
+	b.send(pubcomp)
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
+
+// TestExecuteReconnect starts the target while the broker is unreachable and
+// drops the connection while a message is in flight, which is expected to be
+// resent after reconnecting.
+func TestExecuteReconnect(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := ln.Addr().String()
+	ln.Close()
+
+	m := newMQTT(t, addr, "1")
+	errs := execute(m)
+
+	b := listen(t, addr)
+	b.accept()
+	pub := b.expect(packets.Publish).(*packets.PublishPacket)
+	b.conn.Close()
+
+	b.accept()
+	resent := b.expect(packets.Publish).(*packets.PublishPacket)
+	if resent.MessageID != pub.MessageID ||
+		string(resent.Payload) != string(pub.Payload) {
+		t.Fatalf("broker received %s, want the message in flight", resent.String())
+	}
+
+	puback := packets.NewControlPacket(packets.Puback).(*packets.PubackPacket)
+	puback.MessageID = resent.MessageID
+	b.send(puback)
+
+	if err := <-errs; err != nil {
+		t.Fatal(err)
+	}
+}
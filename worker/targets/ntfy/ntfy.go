@@ -0,0 +1,232 @@
+package ntfy
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT = 30 * time.Second
+)
+
+type Ntfy struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	server string
+	client *http.Client
+}
+
+type action struct {
+	Action string `json:"action"`
+	Label  string `json:"label"`
+	URL    string `json:"url"`
+}
+
+type publish struct {
+	Topic    string   `json:"topic"`
+	Title    string   `json:"title,omitempty"`
+	Message  string   `json:"message"`
+	Priority int      `json:"priority,omitempty"`
+	Click    string   `json:"click,omitempty"`
+	Actions  []action `json:"actions,omitempty"`
+	Attach   string   `json:"attach,omitempty"`
+	Filename string   `json:"filename,omitempty"`
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*Ntfy, error) {
+	t := new(Ntfy)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *Ntfy) Load() error {
+	t.log.Info("Load target: ntfy")
+
+	server, ok := helpers.GetArg(t.targetCfg.Args, "server")
+	if !ok || server == "" {
+		return errors.New("Could not get ntfy server")
+	}
+	t.server = strings.TrimSuffix(server, "/")
+
+	t.client = &http.Client{Timeout: TIMEOUT}
+
+	return nil
+}
+
+func (t *Ntfy) Run() error {
+	t.log.Info("Run target: ntfy")
+	return nil
+}
+
+// priority maps Pushover priorities (-2 to 2) to ntfy priorities (1 to 5)
+func priority(p int) int {
+	switch {
+	case p <= -2:
+		return 1
+	case p == -1:
+		return 2
+	case p == 1:
+		return 4
+	case p >= 2:
+		return 5
+	}
+	return 3
+}
+
+func (t *Ntfy) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	topic, ok := helpers.GetArg(appArgs, "topic")
+	if !ok || topic == "" {
+		topic, ok = helpers.GetArg(appArgs, "destination")
+	}
+	if !ok || topic == "" {
+		return errors.New("Could not get ntfy topic")
+	}
+
+	pub := publish{
+		Topic:    topic,
+		Title:    m.Title,
+		Message:  m.Message,
+		Priority: priority(m.Priority),
+		Click:    m.URL,
+	}
+	if m.URL != "" {
+		label := m.URLTitle
+		if label == "" {
+			label = "Open"
+		}
+		pub.Actions = []action{{Action: "view", Label: label, URL: m.URL}}
+	}
+
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil {
+		return err
+	}
+
+	var req *http.Request
+	if data != nil {
+		req, err = t.newUploadRequest(pub, data, m.GetAttachmentFilename(mimeType))
+	} else {
+		if u := m.GetAttachmentURL(); u != "" {
+			pub.Attach = u
+			pub.Filename = m.GetAttachmentFilename("")
+		}
+		req, err = t.newPublishRequest(pub)
+	}
+	if err != nil {
+		return err
+	}
+
+	if token, ok := helpers.GetArg(t.targetCfg.Args, "token"); ok && token != "" {
+		req.Header.Set("Authorization", "Bearer "+token)
+	} else if username, ok := helpers.GetArg(
+		t.targetCfg.Args,
+		"username",
+	); ok && username != "" {
+		password, _ := helpers.GetArg(t.targetCfg.Args, "password")
+		req.SetBasicAuth(username, password)
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		t.log.Error("ntfy failed to send",
+			zap.Error(err))
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("ntfy returned %s: %s",
+			resp.Status, strings.TrimSpace(string(body)))
+	}
+
+	t.log.Debug("ntfy successfully sent message",
+		zap.String("topic", topic))
+
+	return nil
+}
+
+// newPublishRequest publishes the message as JSON to the server's root URL.
+func (t *Ntfy) newPublishRequest(pub publish) (*http.Request, error) {
+	body, err := json.Marshal(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequest("POST", t.server+"/", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	return req, nil
+}
+
+// newUploadRequest uploads the attachment as request body to the topic URL,
+// with the message fields in the headers, which is the only way ntfy accepts
+// file attachments.
+func (t *Ntfy) newUploadRequest(
+	pub publish,
+	data []byte,
+	filename string,
+) (*http.Request, error) {
+	req, err := http.NewRequest(
+		"PUT",
+		t.server+"/"+pub.Topic,
+		bytes.NewReader(data),
+	)
+	if err != nil {
+		return nil, err
+	}
+
+	// ntfy decodes RFC 2047 encoded headers, which allows for UTF-8
+	req.Header.Set("Filename", filename)
+	req.Header.Set("Message", mime.QEncoding.Encode("utf-8", pub.Message))
+	req.Header.Set("Priority", fmt.Sprint(pub.Priority))
+	if pub.Title != "" {
+		req.Header.Set("Title", mime.QEncoding.Encode("utf-8", pub.Title))
+	}
+	if pub.Click != "" {
+		req.Header.Set("Click", pub.Click)
+	}
+	if len(pub.Actions) > 0 {
+		actions, err := json.Marshal(pub.Actions)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Actions", mime.QEncoding.Encode("utf-8", string(actions)))
+	}
+
+	return req, nil
+}
+
+func (t *Ntfy) Shutdown() error {
+	t.log.Info("Shutdown target: ntfy")
+	return nil
+}
@@ -0,0 +1,551 @@
+package signal
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"mime"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT = 30 * time.Second
+	// DEFAULT_RATE_LIMIT_DELAY is used for rate limits that do not specify
+	// when to retry
+	DEFAULT_RATE_LIMIT_DELAY = 15 * time.Minute
+)
+
+var (
+	ErrUntrustedIdentity = errors.New("Signal identity is untrusted")
+	ErrRateLimited       = errors.New("Signal rate limit reached")
+	ErrUnregistered      = errors.New("Signal recipient is not registered")
+)
+
+type Signal struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	account string
+	socket  string
+	url     string
+	client  *http.Client
+
+	conn   *rpcConn
+	connMu sync.Mutex
+	nextID int64
+}
+
+// rpcConn is a connection to the signal-cli daemon along with the calls that
+// are waiting for a response on it, so that a connection that is closed only
+// fails its own calls.
+type rpcConn struct {
+	net.Conn
+	pending   map[string]chan rpcResponse
+	pendingMu sync.Mutex
+}
+
+type rpcError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+	Data    struct {
+		Response sendResult `json:"response"`
+	} `json:"data"`
+}
+
+type rpcResponse struct {
+	ID     string          `json:"id"`
+	Method string          `json:"method"`
+	Result json.RawMessage `json:"result"`
+	Error  *rpcError       `json:"error"`
+}
+
+type sendResult struct {
+	Results []struct {
+		RecipientAddress struct {
+			UUID   string `json:"uuid"`
+			Number string `json:"number"`
+		} `json:"recipientAddress"`
+		GroupID           string `json:"groupId"`
+		Type              string `json:"type"`
+		RetryAfterSeconds int    `json:"retryAfterSeconds"`
+	} `json:"results"`
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*Signal, error) {
+	t := new(Signal)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *Signal) Load() error {
+	t.log.Info("Load target: Signal")
+
+	t.account, _ = helpers.GetArg(t.targetCfg.Args, "account")
+	t.socket, _ = helpers.GetArg(t.targetCfg.Args, "socket")
+	t.url, _ = helpers.GetArg(t.targetCfg.Args, "url")
+	t.url = strings.TrimSuffix(t.url, "/")
+
+	if (t.socket == "") == (t.url == "") {
+		return errors.New("Signal requires either a socket or a url")
+	}
+	if t.url != "" && t.account == "" {
+		return errors.New("Could not get Signal account")
+	}
+
+	t.client = &http.Client{Timeout: TIMEOUT}
+
+	return nil
+}
+
+func (t *Signal) Run() error {
+	t.log.Info("Run target: Signal")
+
+	if t.socket == "" {
+		return nil
+	}
+
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+	return t.reconnect()
+}
+
+// reconnect (re)establishes the connection to the signal-cli daemon's
+// JSON-RPC socket. connMu must be held.
+func (t *Signal) reconnect() error {
+	if t.conn != nil {
+		t.log.Debug("Signal close existing connection")
+		t.conn.Close()
+		t.conn = nil
+	}
+
+	t.log.Debug("Signal connect to daemon ...",
+		zap.String("Socket", t.socket))
+	conn, err := net.DialTimeout("unix", t.socket, TIMEOUT)
+	if err != nil {
+		t.log.Error("Signal failed to connect",
+			zap.Error(err))
+		return err
+	}
+	t.conn = &rpcConn{
+		Conn:    conn,
+		pending: make(map[string]chan rpcResponse),
+	}
+
+	go t.listen(t.conn)
+
+	return nil
+}
+
+// listen reads responses from the connection until it is closed and passes
+// them to the pending calls.
+func (t *Signal) listen(conn *rpcConn) {
+	r := bufio.NewReader(conn)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			t.log.Debug("Signal stopped receiving",
+				zap.Error(err))
+			break
+		}
+
+		var resp rpcResponse
+		if err := json.Unmarshal(line, &resp); err != nil {
+			t.log.Debug("Signal received invalid response",
+				zap.Error(err))
+			continue
+		}
+		if resp.ID == "" {
+			// Notifications, e.g. incoming messages, are not of interest
+			continue
+		}
+
+		conn.pendingMu.Lock()
+		ch, ok := conn.pending[resp.ID]
+		delete(conn.pending, resp.ID)
+		conn.pendingMu.Unlock()
+		if ok {
+			ch <- resp
+		}
+	}
+
+	t.connMu.Lock()
+	if t.conn == conn {
+		t.conn.Close()
+		t.conn = nil
+	}
+	t.connMu.Unlock()
+
+	// Fail the calls that are still waiting for a response on this connection
+	conn.pendingMu.Lock()
+	for id, ch := range conn.pending {
+		ch <- rpcResponse{ID: id, Error: &rpcError{
+			Message: "Signal daemon closed the connection",
+		}}
+		delete(conn.pending, id)
+	}
+	conn.pendingMu.Unlock()
+}
+
+// call performs a JSON-RPC call, reconnecting to the daemon if necessary.
+func (t *Signal) call(
+	method string,
+	params map[string]interface{},
+) (json.RawMessage, error) {
+	t.connMu.Lock()
+	if t.conn == nil {
+		if err := t.reconnect(); err != nil {
+			t.connMu.Unlock()
+			return nil, err
+		}
+	}
+	conn := t.conn
+
+	t.nextID++
+	id := strconv.FormatInt(t.nextID, 10)
+	ch := make(chan rpcResponse, 1)
+	conn.pendingMu.Lock()
+	conn.pending[id] = ch
+	conn.pendingMu.Unlock()
+	defer func() {
+		conn.pendingMu.Lock()
+		delete(conn.pending, id)
+		conn.pendingMu.Unlock()
+	}()
+
+	req, err := json.Marshal(map[string]interface{}{
+		"jsonrpc": "2.0",
+		"method":  method,
+		"params":  params,
+		"id":      id,
+	})
+	if err != nil {
+		t.connMu.Unlock()
+		return nil, err
+	}
+
+	conn.SetWriteDeadline(time.Now().Add(TIMEOUT))
+	_, err = conn.Write(append(req, '\n'))
+	if err != nil {
+		t.log.Error("Signal failed to write, dropping connection",
+			zap.Error(err))
+		conn.Close()
+		t.conn = nil
+	}
+	t.connMu.Unlock()
+	if err != nil {
+		return nil, err
+	}
+
+	select {
+	case resp := <-ch:
+		if resp.Error != nil {
+			err := errors.New(resp.Error.Message)
+			if resp.Error.Code >= -32602 && resp.Error.Code <= -32600 {
+				// Invalid request, method or params
+				return nil, target.Permanent(err)
+			}
+			return nil, classify(err, resp.Error.Data.Response)
+		}
+		return resp.Result, nil
+	case <-time.After(TIMEOUT):
+		return nil, errors.New("Signal daemon did not respond in time")
+	}
+}
+
+// classify turns the per-recipient results of a send into an error, if any
+// recipient failed. Untrusted identities, unregistered recipients and required
+// captchas are permanent, as retrying will not help until they are resolved
+// manually, while rate limits are retried no earlier than Signal requests.
+// If the message was delivered to some recipients, failures are permanent as
+// well, as retrying would deliver the message twice to the others.
+func classify(err error, res sendResult) error {
+	var failures []string
+	var succeeded bool = false
+	var permanent bool = false
+	var kind error
+	var retryAfter time.Duration
+
+	for _, r := range res.Results {
+		if r.Type == "SUCCESS" {
+			succeeded = true
+			continue
+		}
+
+		recipient := r.RecipientAddress.Number
+		if recipient == "" {
+			recipient = r.RecipientAddress.UUID
+		}
+		if recipient == "" {
+			recipient = r.GroupID
+		}
+		failures = append(failures, fmt.Sprintf("%s: %s", recipient, r.Type))
+
+		switch r.Type {
+		case "IDENTITY_FAILURE":
+			kind, permanent = ErrUntrustedIdentity, true
+		case "UNREGISTERED_FAILURE":
+			kind, permanent = ErrUnregistered, true
+		case "PROOF_REQUIRED_FAILURE":
+			// A captcha needs to be solved before sending again
+			kind, permanent = ErrRateLimited, true
+		case "RATE_LIMIT_FAILURE":
+			if kind == nil {
+				kind = ErrRateLimited
+			}
+			retryAfter = max(retryAfter,
+				time.Duration(r.RetryAfterSeconds)*time.Second)
+		}
+	}
+
+	if len(failures) == 0 && err == nil {
+		return nil
+	}
+	if len(failures) > 0 {
+		err = fmt.Errorf("Signal failed for %s", strings.Join(failures, ", "))
+	}
+
+	if kind == nil {
+		kind = classifyMessage(err.Error())
+		permanent = kind == ErrUntrustedIdentity || kind == ErrUnregistered
+	}
+	if kind != nil {
+		err = fmt.Errorf("%w: %w", kind, err)
+	}
+
+	switch {
+	case permanent == true || succeeded == true:
+		return target.Permanent(err)
+	case kind == ErrRateLimited:
+		if retryAfter <= 0 {
+			retryAfter = DEFAULT_RATE_LIMIT_DELAY
+		}
+		return target.RetryAfter(err, retryAfter)
+	}
+
+	return err
+}
+
+// classifyMessage recognizes errors by their message, for responses that do
+// not contain per-recipient results.
+func classifyMessage(msg string) error {
+	msg = strings.ToLower(msg)
+
+	switch {
+	case strings.Contains(msg, "untrusted"):
+		return ErrUntrustedIdentity
+	case strings.Contains(msg, "unregistered") ||
+		strings.Contains(msg, "not registered"):
+		return ErrUnregistered
+	case strings.Contains(msg, "rate limit") ||
+		strings.Contains(msg, "ratelimit") ||
+		strings.Contains(msg, "proof required"):
+		return ErrRateLimited
+	}
+
+	return nil
+}
+
+// getRecipients returns the phone numbers (or usernames) and the group ID from
+// the app args.
+func getRecipients(appArgs map[string]interface{}) ([]string, string) {
+	var recipients []string
+
+	destination, _ := helpers.GetArg(appArgs, "destination")
+	for _, r := range strings.Split(destination, ",") {
+		if r = strings.TrimSpace(r); r != "" {
+			recipients = append(recipients, r)
+		}
+	}
+
+	group, _ := helpers.GetArg(appArgs, "group")
+
+	return recipients, strings.TrimSpace(group)
+}
+
+// getAttachments returns the attachment as data URI, which both signal-cli and
+// its REST wrapper accept.
+func getAttachments(m message.Message) ([]string, error) {
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil || data == nil {
+		return nil, err
+	}
+
+	mediaType, _, err := mime.ParseMediaType(mimeType)
+	if err != nil {
+		mediaType = "application/octet-stream"
+	}
+
+	return []string{fmt.Sprintf(
+		"data:%s;filename=%s;base64,%s",
+		mediaType,
+		m.GetAttachmentFilename(mimeType),
+		base64.StdEncoding.EncodeToString(data),
+	)}, nil
+}
+
+func (t *Signal) sendRPC(
+	text string,
+	recipients []string,
+	group string,
+	attachments []string,
+) error {
+	params := map[string]interface{}{
+		"message": text,
+	}
+	if t.account != "" {
+		params["account"] = t.account
+	}
+	if len(recipients) > 0 {
+		params["recipient"] = recipients
+	}
+	if group != "" {
+		params["groupId"] = group
+	}
+	if len(attachments) > 0 {
+		params["attachments"] = attachments
+	}
+
+	result, err := t.call("send", params)
+	if err != nil {
+		return err
+	}
+
+	var res sendResult
+	if err := json.Unmarshal(result, &res); err != nil {
+		return err
+	}
+	return classify(nil, res)
+}
+
+func (t *Signal) sendREST(
+	text string,
+	recipients []string,
+	group string,
+	attachments []string,
+) error {
+	if group != "" {
+		recipients = append(recipients, group)
+	}
+
+	body, err := json.Marshal(map[string]interface{}{
+		"message":            text,
+		"number":             t.account,
+		"recipients":         recipients,
+		"base64_attachments": attachments,
+	})
+	if err != nil {
+		return err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), TIMEOUT)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, "POST", t.url+"/v2/send",
+		bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return nil
+	}
+
+	var rerr struct {
+		Error string `json:"error"`
+	}
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 4096))
+	if json.Unmarshal(respBody, &rerr) != nil || rerr.Error == "" {
+		rerr.Error = strings.TrimSpace(string(respBody))
+	}
+
+	err = fmt.Errorf("Signal returned %s: %s", resp.Status, rerr.Error)
+	if resp.StatusCode == http.StatusTooManyRequests {
+		return target.RetryAfter(fmt.Errorf("%w: %w", ErrRateLimited, err),
+			DEFAULT_RATE_LIMIT_DELAY)
+	}
+	if classifyMessage(rerr.Error) != nil {
+		return classify(err, sendResult{})
+	}
+	if resp.StatusCode >= 400 && resp.StatusCode <= 499 {
+		return target.Permanent(err)
+	}
+	return err
+}
+
+func (t *Signal) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	recipients, group := getRecipients(appArgs)
+	if len(recipients) == 0 && group == "" {
+		return errors.New("Could not get Signal recipient or group")
+	}
+
+	attachments, err := getAttachments(m)
+	if err != nil {
+		return err
+	}
+
+	text := strings.TrimSpace(m.ToString())
+
+	if t.socket != "" {
+		err = t.sendRPC(text, recipients, group, attachments)
+	} else {
+		err = t.sendREST(text, recipients, group, attachments)
+	}
+	if err != nil {
+		t.log.Error("Signal failed to send",
+			zap.Error(err))
+		return err
+	}
+
+	t.log.Debug("Signal successfully sent message",
+		zap.Strings("Recipients", recipients),
+		zap.String("Group", group))
+
+	return nil
+}
+
+func (t *Signal) Shutdown() error {
+	t.log.Info("Shutdown target: Signal")
+
+	t.connMu.Lock()
+	defer t.connMu.Unlock()
+	if t.conn != nil {
+		t.conn.Close()
+		t.conn = nil
+	}
+
+	return nil
+}
@@ -0,0 +1,131 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrusme/overpush/models/target"
+)
+
+type apiResponse struct {
+	OK        bool   `json:"ok"`
+	Error     string `json:"error"`
+	UploadURL string `json:"upload_url"`
+	FileID    string `json:"file_id"`
+}
+
+// upload uploads the attachment data to the channel using the Web API's
+// external upload flow, see https://api.slack.com/messaging/files#uploading_files
+func (t *Slack) upload(
+	channel string,
+	filename string,
+	title string,
+	data []byte,
+) error {
+	params := url.Values{}
+	params.Set("filename", filename)
+	params.Set("length", strconv.Itoa(len(data)))
+
+	var uploadResp apiResponse
+	if err := t.api(
+		"files.getUploadURLExternal",
+		"application/x-www-form-urlencoded",
+		strings.NewReader(params.Encode()),
+		&uploadResp,
+	); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", uploadResp.UploadURL,
+		bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/octet-stream")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return errors.New("Slack file upload failed")
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 1024))
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Slack file upload returned %s", resp.Status)
+	}
+
+	if title == "" {
+		title = filename
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"files":      []map[string]string{{"id": uploadResp.FileID, "title": title}},
+		"channel_id": channel,
+	})
+	if err != nil {
+		return err
+	}
+
+	return t.api(
+		"files.completeUploadExternal",
+		"application/json; charset=utf-8",
+		bytes.NewReader(body),
+		nil,
+	)
+}
+
+// api calls the Web API method using the bot token. Errors that retrying
+// cannot fix (e.g. missing scopes or unknown channels) are permanent.
+func (t *Slack) api(
+	method string,
+	contentType string,
+	body io.Reader,
+	out *apiResponse,
+) error {
+	req, err := http.NewRequest("POST", t.apiURL+"/"+method, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("Authorization", "Bearer "+t.token)
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Slack %s failed", method)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusTooManyRequests {
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return target.RetryAfter(
+			fmt.Errorf("Slack %s returned %s", method, resp.Status),
+			max(time.Duration(retryAfter)*time.Second, MIN_RETRY_AFTER))
+	}
+
+	var aresp apiResponse
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(respBody, &aresp); err != nil {
+		return fmt.Errorf("Slack %s returned %s", method, resp.Status)
+	}
+	if aresp.OK == false {
+		err := fmt.Errorf("Slack %s returned %s", method, aresp.Error)
+		switch aresp.Error {
+		case "internal_error", "fatal_error", "service_unavailable",
+			"request_timeout", "ratelimited":
+			return err
+		}
+		return target.Permanent(err)
+	}
+
+	if out != nil {
+		*out = aresp
+	}
+	return nil
+}
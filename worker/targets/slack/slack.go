@@ -0,0 +1,258 @@
+package slack
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT = 30 * time.Second
+	API_URL = "https://slack.com/api"
+	// MIN_RETRY_AFTER is the delay used for rate limits without (positive)
+	// `Retry-After` header
+	MIN_RETRY_AFTER = 1 * time.Second
+
+	MAX_HEADER_LENGTH  = 150
+	MAX_SECTION_LENGTH = 3000
+	MAX_BUTTON_LENGTH  = 75
+)
+
+type Slack struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	// token is the optional bot token used for uploading attachments
+	token  string
+	apiURL string
+	client *http.Client
+}
+
+type block map[string]interface{}
+
+type attachment struct {
+	Color  string  `json:"color"`
+	Blocks []block `json:"blocks"`
+}
+
+type payload struct {
+	Text        string       `json:"text"`
+	Attachments []attachment `json:"attachments"`
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*Slack, error) {
+	t := new(Slack)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *Slack) Load() error {
+	t.log.Info("Load target: Slack")
+
+	if _, ok := helpers.GetArg(t.targetCfg.Args, "webhook"); !ok {
+		return errors.New("Could not get Slack webhook")
+	}
+
+	t.token, _ = helpers.GetArg(t.targetCfg.Args, "token")
+	t.apiURL = API_URL
+	if apiURL, ok := helpers.GetArg(t.targetCfg.Args, "apiurl"); ok && apiURL != "" {
+		t.apiURL = strings.TrimSuffix(apiURL, "/")
+	}
+
+	t.client = &http.Client{Timeout: TIMEOUT}
+
+	return nil
+}
+
+func (t *Slack) Run() error {
+	t.log.Info("Run target: Slack")
+	return nil
+}
+
+func text(kind string, s string) map[string]interface{} {
+	return map[string]interface{}{"type": kind, "text": s}
+}
+
+var mrkdwnEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;")
+
+// escape escapes the control characters of Slack's mrkdwn and truncates the
+// result to `max` characters, without cutting escaped characters apart.
+func escape(s string, max int) string {
+	escaped := mrkdwnEscaper.Replace(s)
+	if utf8.RuneCountInString(escaped) <= max {
+		return escaped
+	}
+	if max <= 0 {
+		return ""
+	}
+
+	// Truncate on whole escaped characters, leaving room for the ellipsis
+	var b strings.Builder
+	var n int = 0
+	for _, r := range s {
+		token := mrkdwnEscaper.Replace(string(r))
+		length := utf8.RuneCountInString(token)
+		if n+length > max-1 {
+			break
+		}
+		b.WriteString(token)
+		n += length
+	}
+	return b.String() + "…"
+}
+
+func (t *Slack) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	webhookTmpl, _ := helpers.GetArg(t.targetCfg.Args, "webhook")
+	webhook, ok := helpers.GetFieldValue(webhookTmpl, appArgs)
+	if !ok || webhook == "" {
+		return errors.New("Could not parse webhook argument")
+	}
+
+	var blocks []block
+	if m.Title != "" {
+		blocks = append(blocks, block{
+			"type": "header",
+			"text": text("plain_text", helpers.Truncate(m.Title, MAX_HEADER_LENGTH)),
+		})
+	}
+	if m.Message != "" {
+		blocks = append(blocks, block{
+			"type": "section",
+			"text": text("mrkdwn", escape(m.Message, MAX_SECTION_LENGTH)),
+		})
+	}
+	if u := m.GetAttachmentURL(); u != "" {
+		blocks = append(blocks, block{
+			"type":      "image",
+			"image_url": u,
+			"alt_text":  m.GetAttachmentFilename(""),
+		})
+	}
+	if m.URL != "" {
+		label := m.URLTitle
+		if label == "" {
+			label = "Open"
+		}
+		blocks = append(blocks, block{
+			"type": "actions",
+			"elements": []block{{
+				"type": "button",
+				"text": text("plain_text", helpers.Truncate(label, MAX_BUTTON_LENGTH)),
+				"url":  m.URL,
+			}},
+		})
+	}
+
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil {
+		return err
+	}
+	channel, _ := helpers.GetArg(appArgs, "channel")
+	if channel == "" {
+		channel, _ = helpers.GetArg(t.targetCfg.Args, "channel")
+	}
+
+	ts := m.GetTime()
+	elements := []block{text("mrkdwn", fmt.Sprintf(
+		"<!date^%d^{date_short_pretty} {time}|%s>",
+		ts.Unix(), ts.UTC().Format(time.RFC1123)))}
+	// Incoming webhooks cannot upload files, which requires a bot token
+	if data != nil && (t.token == "" || channel == "") {
+		t.log.Warn("Slack cannot upload attachment without token and channel")
+		elements = append(elements, text("mrkdwn", fmt.Sprintf(
+			"Attachment %s omitted", escape(m.GetAttachmentFilename(mimeType), 100))))
+	}
+	blocks = append(blocks, block{
+		"type":     "context",
+		"elements": elements,
+	})
+
+	fallback := m.Title
+	if fallback == "" {
+		fallback = m.Message
+	}
+
+	body, err := json.Marshal(payload{
+		Text: escape(fallback, MAX_SECTION_LENGTH),
+		Attachments: []attachment{{
+			Color:  fmt.Sprintf("#%06x", m.GetPriorityColor()),
+			Blocks: blocks,
+		}},
+	})
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST", webhook, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// Note: The error contains the webhook URL, which is a secret
+		t.log.Error("Slack failed to send")
+		return errors.New("Slack webhook request failed")
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		t.log.Debug("Slack successfully sent message")
+		if data == nil || t.token == "" || channel == "" {
+			return nil
+		}
+		err := t.upload(channel, m.GetAttachmentFilename(mimeType), m.Title, data)
+		if err != nil {
+			// Retrying the delivery would post the message again
+			t.log.Error("Slack failed to upload attachment after sending message, "+
+				"not retrying",
+				zap.Error(err))
+			return target.Permanent(err)
+		}
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("Slack returned %s: %s",
+		resp.Status, strings.TrimSpace(string(respBody)))
+	switch {
+	case resp.StatusCode == http.StatusTooManyRequests:
+		retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+		return target.RetryAfter(err,
+			max(time.Duration(retryAfter)*time.Second, MIN_RETRY_AFTER))
+	case resp.StatusCode >= 400 && resp.StatusCode <= 499:
+		return target.Permanent(err)
+	}
+	return err
+}
+
+func (t *Slack) Shutdown() error {
+	t.log.Info("Shutdown target: Slack")
+	return nil
+}
@@ -0,0 +1,83 @@
+package slack
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"unicode/utf8"
+
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+func TestEscape(t *testing.T) {
+	for _, tc := range []struct {
+		s    string
+		max  int
+		want string
+	}{
+		{"<a & b>", 100, "&lt;a &amp; b&gt;"},
+		{"a&b", 5, "a…"},
+		{"a&b", 6, "a…"},
+		{"a&b", 7, "a&amp;b"},
+		{"&&&", 11, "&amp;&amp;…"},
+		{"&", 0, ""},
+		{"&", 1, "…"},
+	} {
+		if got := escape(tc.s, tc.max); got != tc.want {
+			t.Errorf("escape(%q, %d) = %q, want %q", tc.s, tc.max, got, tc.want)
+		}
+	}
+}
+
+// TestExecuteEntityHeavyBody is a regression test for bodies that grow beyond
+// the maximum section length when being escaped.
+func TestExecuteEntityHeavyBody(t *testing.T) {
+	var received payload
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			if err := json.NewDecoder(r.Body).Decode(&received); err != nil {
+				t.Error(err)
+			}
+		}))
+	defer srv.Close()
+
+	s, err := New(nil, zap.NewNop(), target.Target{
+		Enable: true,
+		ID:     "slack",
+		Type:   "slack",
+		Args:   map[string]interface{}{"webhook": srv.URL},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	body := strings.Repeat("&", 4000)
+	if err := s.Execute(message.Message{Message: body}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, txt := range []string{
+		received.Text,
+		received.Attachments[0].Blocks[0]["text"].(map[string]interface{})["text"].(string),
+	} {
+		if n := utf8.RuneCountInString(txt); n > MAX_SECTION_LENGTH {
+			t.Errorf("text has %d characters, want at most %d",
+				n, MAX_SECTION_LENGTH)
+		}
+		if strings.HasSuffix(txt, "…") == false {
+			t.Errorf("text %q... is not truncated", txt[:20])
+		}
+		// Only whole entities are left, once they are removed
+		if rest := strings.ReplaceAll(strings.TrimSuffix(txt, "…"), "&amp;",
+			""); rest != "" {
+			t.Errorf("text contains cut entities: %q", rest)
+		}
+	}
+}
@@ -0,0 +1,534 @@
+package smtp
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime"
+	"mime/multipart"
+	"mime/quotedprintable"
+	"net"
+	"net/mail"
+	"net/smtp"
+	"net/textproto"
+	"regexp"
+	"strings"
+	"time"
+
+	"filippo.io/age"
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT      = 30 * time.Second
+	DEFAULT_PORT = "587"
+	// AGE_HEADER is the first line of every age-encrypted file, which is used to
+	// detect fields that were encrypted by the application's `EncryptionType`
+	AGE_HEADER = "age-encryption.org/v1\n"
+	// ENCRYPTED_BODY is sent instead of the body of age-encrypted messages
+	ENCRYPTED_BODY = "This message is encrypted, see the attached message.age file.\n"
+)
+
+var htmlTags = regexp.MustCompile(`(?s)<[^>]*>`)
+
+type SMTP struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	host       string
+	port       string
+	security   string
+	username   string
+	password   string
+	from       *mail.Address
+	recipients []age.Recipient
+}
+
+type part struct {
+	filename string
+	mimeType string
+	data     []byte
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*SMTP, error) {
+	t := new(SMTP)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *SMTP) Load() error {
+	t.log.Info("Load target: SMTP")
+
+	var ok bool
+	t.host, ok = helpers.GetArg(t.targetCfg.Args, "host")
+	if !ok || t.host == "" {
+		return errors.New("Could not get SMTP host")
+	}
+
+	t.port = DEFAULT_PORT
+	if port, ok := helpers.GetArg(t.targetCfg.Args, "port"); ok && port != "" {
+		t.port = port
+	}
+
+	t.security = "starttls"
+	if security, ok := helpers.GetArg(t.targetCfg.Args, "security"); ok && security != "" {
+		t.security = strings.ToLower(security)
+	}
+	switch t.security {
+	case "starttls", "tls", "none":
+	default:
+		return errors.New("SMTP security must be one of starttls, tls or none")
+	}
+
+	t.username, _ = helpers.GetArg(t.targetCfg.Args, "username")
+	t.password, _ = helpers.GetArg(t.targetCfg.Args, "password")
+	// Note: net/smtp refuses to send credentials over unencrypted connections
+	// to any host other than localhost.
+	if t.security == "none" && t.username != "" && isLocalhost(t.host) == false {
+		return errors.New("SMTP authentication requires security starttls or " +
+			"tls, unless the host is localhost")
+	}
+
+	from, ok := helpers.GetArg(t.targetCfg.Args, "from")
+	if !ok || from == "" {
+		return errors.New("Could not get SMTP from address")
+	}
+	var err error
+	if t.from, err = mail.ParseAddress(from); err != nil {
+		return err
+	}
+
+	if t.recipients, err = parseAgeRecipients(t.targetCfg.Args["agerecipients"]); err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (t *SMTP) Run() error {
+	t.log.Info("Run target: SMTP")
+	return nil
+}
+
+func isLocalhost(host string) bool {
+	return host == "localhost" || host == "127.0.0.1" || host == "::1"
+}
+
+// parseAgeRecipients parses a list (or comma-separated string) of age X25519
+// recipients.
+func parseAgeRecipients(val interface{}) ([]age.Recipient, error) {
+	var strs []string
+
+	switch casted := val.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		strs = strings.Split(casted, ",")
+	case []interface{}:
+		for _, v := range casted {
+			strs = append(strs, fmt.Sprint(v))
+		}
+	case []string:
+		strs = casted
+	default:
+		return nil, errors.New("Could not parse age recipients")
+	}
+
+	var rcpts []age.Recipient
+	for _, s := range strs {
+		s = strings.TrimSpace(s)
+		if s == "" {
+			continue
+		}
+		rcpt, err := age.ParseX25519Recipient(s)
+		if err != nil {
+			return nil, err
+		}
+		rcpts = append(rcpts, rcpt)
+	}
+
+	return rcpts, nil
+}
+
+func encrypt(data []byte, rcpts []age.Recipient) ([]byte, error) {
+	out := &bytes.Buffer{}
+	w, err := age.Encrypt(out, rcpts...)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(data); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return out.Bytes(), nil
+}
+
+func isAgeEncrypted(s string) bool {
+	return strings.HasPrefix(s, AGE_HEADER)
+}
+
+// bodies returns the plain-text and the HTML body of the message.
+func bodies(m message.Message) (string, string) {
+	var text, htm string
+
+	if m.HTML == 1 {
+		htm = m.Message
+		text = html.UnescapeString(htmlTags.ReplaceAllString(m.Message, ""))
+	} else {
+		text = m.Message
+		htm = strings.ReplaceAll(html.EscapeString(m.Message), "\n", "<br>\n")
+	}
+
+	if m.URL != "" {
+		label := m.URLTitle
+		if label == "" {
+			label = m.URL
+		}
+		text = fmt.Sprintf("%s\n\n%s: %s", text, label, m.URL)
+		htm = fmt.Sprintf("%s\n<p><a href=\"%s\">%s</a></p>", htm,
+			html.EscapeString(m.URL), html.EscapeString(label))
+	}
+
+	if u := m.GetAttachmentURL(); u != "" {
+		text = fmt.Sprintf("%s\n\nAttachment: %s", text, u)
+		htm = fmt.Sprintf("%s\n<p><a href=\"%s\">%s</a></p>", htm,
+			html.EscapeString(u), html.EscapeString(m.GetAttachmentFilename("")))
+	}
+
+	return text + "\n", htm + "\n"
+}
+
+// priorityHeader returns the X-Priority (1 = highest, 5 = lowest) for the
+// message priority.
+func priorityHeader(priority int) string {
+	switch {
+	case priority >= 2:
+		return "1 (Highest)"
+	case priority == 1:
+		return "2 (High)"
+	case priority == -1:
+		return "4 (Low)"
+	case priority <= -2:
+		return "5 (Lowest)"
+	}
+	return "3 (Normal)"
+}
+
+func messageID(domain string) string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return fmt.Sprintf("<%s@%s>", hex.EncodeToString(b), domain)
+}
+
+func writeQuotedPrintable(w io.Writer, s string) error {
+	qp := quotedprintable.NewWriter(w)
+	if _, err := io.WriteString(qp, s); err != nil {
+		return err
+	}
+	return qp.Close()
+}
+
+func writeBase64(w io.Writer, data []byte) error {
+	enc := base64.StdEncoding.EncodeToString(data)
+	for len(enc) > 76 {
+		if _, err := io.WriteString(w, enc[:76]+"\r\n"); err != nil {
+			return err
+		}
+		enc = enc[76:]
+	}
+	_, err := io.WriteString(w, enc+"\r\n")
+	return err
+}
+
+// writeAlternative writes the multipart/alternative plain-text and HTML body.
+func writeAlternative(w *multipart.Writer, text string, htm string) error {
+	for _, p := range []struct{ mimeType, body string }{
+		{"text/plain", text},
+		{"text/html", htm},
+	} {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", p.mimeType+"; charset=utf-8")
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return err
+		}
+		if err := writeQuotedPrintable(pw, p.body); err != nil {
+			return err
+		}
+	}
+	return w.Close()
+}
+
+// compose builds the RFC 5322 message.
+func (t *SMTP) compose(
+	m message.Message,
+	to []*mail.Address,
+) ([]byte, error) {
+	var parts []part
+	var encrypted bool = false
+
+	subject := m.Title
+	if isAgeEncrypted(subject) {
+		parts = append(parts, part{"title.age", "application/octet-stream",
+			[]byte(subject)})
+		subject = "Encrypted message"
+		encrypted = true
+	}
+
+	text, htm := bodies(m)
+	if isAgeEncrypted(m.Message) {
+		parts = append(parts, part{"message.age", "application/octet-stream",
+			[]byte(m.Message)})
+		encrypted = true
+	} else if len(t.recipients) > 0 {
+		data, err := encrypt([]byte(text), t.recipients)
+		if err != nil {
+			return nil, err
+		}
+		parts = append(parts, part{"message.age", "application/octet-stream",
+			data})
+		encrypted = true
+	}
+	if encrypted == true {
+		text, htm = ENCRYPTED_BODY, ""
+	}
+
+	if isAgeEncrypted(m.AttachmentBase64) || isAgeEncrypted(m.Attachment) {
+		data := m.AttachmentBase64
+		if data == "" {
+			data = m.Attachment
+		}
+		parts = append(parts, part{"attachment.age", "application/octet-stream",
+			[]byte(data)})
+	} else {
+		data, mimeType, err := m.GetAttachmentData()
+		if err != nil {
+			return nil, err
+		}
+		if data != nil {
+			parts = append(parts, part{m.GetAttachmentFilename(mimeType),
+				mimeType, data})
+		}
+	}
+
+	if subject == "" {
+		subject = helpers.Truncate(strings.SplitN(text, "\n", 2)[0], 78)
+	}
+
+	var addrs []string
+	for _, addr := range to {
+		addrs = append(addrs, addr.String())
+	}
+
+	domain := t.from.Address[strings.LastIndex(t.from.Address, "@")+1:]
+
+	buf := &bytes.Buffer{}
+	fmt.Fprintf(buf, "From: %s\r\n", t.from.String())
+	fmt.Fprintf(buf, "To: %s\r\n", strings.Join(addrs, ", "))
+	fmt.Fprintf(buf, "Subject: %s\r\n", mime.QEncoding.Encode("utf-8", subject))
+	fmt.Fprintf(buf, "Date: %s\r\n", m.GetTime().Format(time.RFC1123Z))
+	fmt.Fprintf(buf, "Message-ID: %s\r\n", messageID(domain))
+	fmt.Fprintf(buf, "X-Priority: %s\r\n", priorityHeader(m.Priority))
+	fmt.Fprintf(buf, "MIME-Version: 1.0\r\n")
+
+	if len(parts) == 0 {
+		w := multipart.NewWriter(buf)
+		fmt.Fprintf(buf, "Content-Type: multipart/alternative; boundary=%s\r\n\r\n",
+			w.Boundary())
+		if err := writeAlternative(w, text, htm); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+
+	w := multipart.NewWriter(buf)
+	fmt.Fprintf(buf, "Content-Type: multipart/mixed; boundary=%s\r\n\r\n",
+		w.Boundary())
+
+	if htm == "" {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "text/plain; charset=utf-8")
+		h.Set("Content-Transfer-Encoding", "quoted-printable")
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeQuotedPrintable(pw, text); err != nil {
+			return nil, err
+		}
+	} else {
+		alt := &bytes.Buffer{}
+		aw := multipart.NewWriter(alt)
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", "multipart/alternative; boundary="+aw.Boundary())
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeAlternative(aw, text, htm); err != nil {
+			return nil, err
+		}
+		if _, err := pw.Write(alt.Bytes()); err != nil {
+			return nil, err
+		}
+	}
+
+	for _, p := range parts {
+		h := make(textproto.MIMEHeader)
+		h.Set("Content-Type", p.mimeType)
+		h.Set("Content-Transfer-Encoding", "base64")
+		h.Set("Content-Disposition", mime.FormatMediaType("attachment",
+			map[string]string{"filename": p.filename}))
+		pw, err := w.CreatePart(h)
+		if err != nil {
+			return nil, err
+		}
+		if err := writeBase64(pw, p.data); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// dial connects to the SMTP server and authenticates, if configured.
+func (t *SMTP) dial() (*smtp.Client, error) {
+	addr := net.JoinHostPort(t.host, t.port)
+	tlsCfg := &tls.Config{ServerName: t.host}
+	dialer := &net.Dialer{Timeout: TIMEOUT}
+
+	var conn net.Conn
+	var err error
+	if t.security == "tls" {
+		conn, err = tls.DialWithDialer(dialer, "tcp", addr, tlsCfg)
+	} else {
+		conn, err = dialer.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(time.Now().Add(TIMEOUT))
+
+	c, err := smtp.NewClient(conn, t.host)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+
+	if t.security == "starttls" {
+		if ok, _ := c.Extension("STARTTLS"); !ok {
+			c.Close()
+			return nil, errors.New("SMTP server does not support STARTTLS")
+		}
+		if err := c.StartTLS(tlsCfg); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	if t.username != "" {
+		if err := c.Auth(
+			smtp.PlainAuth("", t.username, t.password, t.host),
+		); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func (t *SMTP) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	rcpt, ok := helpers.GetArg(appArgs, "to")
+	if !ok || rcpt == "" {
+		rcpt, ok = helpers.GetArg(appArgs, "destination")
+	}
+	if !ok || rcpt == "" {
+		return errors.New("Could not get SMTP recipient")
+	}
+	to, err := mail.ParseAddressList(rcpt)
+	if err != nil {
+		return target.Permanent(err)
+	}
+
+	msg, err := t.compose(m, to)
+	if err != nil {
+		return err
+	}
+
+	c, err := t.dial()
+	if err != nil {
+		return smtpError(err)
+	}
+	defer c.Close()
+
+	if err := c.Mail(t.from.Address); err != nil {
+		return smtpError(err)
+	}
+	for _, addr := range to {
+		if err := c.Rcpt(addr.Address); err != nil {
+			return smtpError(err)
+		}
+	}
+
+	w, err := c.Data()
+	if err != nil {
+		return smtpError(err)
+	}
+	if _, err := w.Write(msg); err != nil {
+		return smtpError(err)
+	}
+	if err := w.Close(); err != nil {
+		return smtpError(err)
+	}
+
+	if err := c.Quit(); err != nil {
+		t.log.Debug("SMTP failed to quit", zap.Error(err))
+	}
+
+	t.log.Debug("SMTP successfully sent message")
+	return nil
+}
+
+// smtpError marks permanent (5xx) SMTP errors as such, so that they are not
+// retried.
+func smtpError(err error) error {
+	var perr *textproto.Error
+	if errors.As(err, &perr) && perr.Code >= 500 && perr.Code <= 599 {
+		return target.Permanent(err)
+	}
+	return err
+}
+
+func (t *SMTP) Shutdown() error {
+	t.log.Info("Shutdown target: SMTP")
+	return nil
+}
@@ -0,0 +1,234 @@
+package smtp
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/base64"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net"
+	"net/mail"
+	"strings"
+	"testing"
+
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+// serve accepts a single connection on l, speaks just enough SMTP to accept
+// one message and sends the received DATA on the returned channel.
+func serve(l net.Listener) <-chan []byte {
+	data := make(chan []byte, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+
+		r := bufio.NewReader(conn)
+		reply := func(s string) {
+			io.WriteString(conn, s+"\r\n")
+		}
+
+		reply("220 localhost ESMTP")
+		for {
+			line, err := r.ReadString('\n')
+			if err != nil {
+				return
+			}
+			cmd := strings.ToUpper(strings.TrimSpace(line))
+			switch {
+			case strings.HasPrefix(cmd, "EHLO"):
+				reply("250-localhost")
+				reply("250 AUTH PLAIN")
+			case strings.HasPrefix(cmd, "AUTH PLAIN"):
+				reply("235 2.7.0 Authentication successful")
+			case strings.HasPrefix(cmd, "MAIL FROM:"),
+				strings.HasPrefix(cmd, "RCPT TO:"):
+				reply("250 OK")
+			case cmd == "DATA":
+				reply("354 Go ahead")
+				buf := &bytes.Buffer{}
+				for {
+					line, err := r.ReadString('\n')
+					if err != nil {
+						return
+					}
+					if line == ".\r\n" {
+						break
+					}
+					buf.WriteString(strings.TrimPrefix(line, "."))
+				}
+				data <- buf.Bytes()
+				reply("250 OK")
+			case cmd == "QUIT":
+				reply("221 Bye")
+				return
+			default:
+				reply("502 Command not implemented")
+			}
+		}
+	}()
+
+	return data
+}
+
+func newTarget(t *testing.T, args map[string]interface{}) (*SMTP, error) {
+	tc := target.Target{Enable: true, ID: "smtp", Type: "smtp", Args: args}
+	s, err := New(nil, zap.NewNop(), tc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, s.Load()
+}
+
+func TestExecute(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	received := serve(l)
+
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	s, err := newTarget(t, map[string]interface{}{
+		"host":     "127.0.0.1",
+		"port":     port,
+		"security": "none",
+		"username": "overpush",
+		"password": "hunter2",
+		"from":     "Overpush <overpush@example.com>",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	attachment := make([]byte, 200)
+	for i := range attachment {
+		attachment[i] = byte(i)
+	}
+	m := message.Message{
+		Title:            "Hällo",
+		Message:          "<b>Hello</b> world",
+		HTML:             1,
+		Priority:         1,
+		AttachmentBase64: base64.StdEncoding.EncodeToString(attachment),
+		AttachmentType:   "image/png",
+	}
+	if err := s.Execute(m, map[string]interface{}{
+		"destination": "you@example.com",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	msg, err := mail.ReadMessage(bytes.NewReader(<-received))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for header, want := range map[string]string{
+		"From":         `"Overpush" <overpush@example.com>`,
+		"To":           "<you@example.com>",
+		"X-Priority":   "2 (High)",
+		"MIME-Version": "1.0",
+	} {
+		if got := msg.Header.Get(header); got != want {
+			t.Errorf("%s = %q, want %q", header, got, want)
+		}
+	}
+	subject, err := new(mime.WordDecoder).DecodeHeader(msg.Header.Get("Subject"))
+	if err != nil || subject != "Hällo" {
+		t.Errorf("Subject = %q (%v), want %q", subject, err, "Hällo")
+	}
+
+	mediaType, params, err := mime.ParseMediaType(msg.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" {
+		t.Fatalf("Content-Type = %q (%v), want multipart/mixed", mediaType, err)
+	}
+	mr := multipart.NewReader(msg.Body, params["boundary"])
+
+	// The body, consisting of a plain-text and an HTML alternative
+	p, err := mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	mediaType, params, err = mime.ParseMediaType(p.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/alternative" {
+		t.Fatalf("body Content-Type = %q (%v), want multipart/alternative",
+			mediaType, err)
+	}
+	ar := multipart.NewReader(p, params["boundary"])
+	for _, want := range []struct{ mediaType, body string }{
+		{"text/plain", "Hello world\r\n"},
+		{"text/html", "<b>Hello</b> world\r\n"},
+	} {
+		ap, err := ar.NextPart()
+		if err != nil {
+			t.Fatal(err)
+		}
+		mediaType, _, _ := mime.ParseMediaType(ap.Header.Get("Content-Type"))
+		// multipart.Reader transparently decodes quoted-printable parts
+		body, _ := io.ReadAll(ap)
+		if mediaType != want.mediaType || string(body) != want.body {
+			t.Errorf("alternative = %q %q, want %q %q",
+				mediaType, body, want.mediaType, want.body)
+		}
+	}
+	if _, err := ar.NextPart(); err != io.EOF {
+		t.Errorf("expected two alternatives, got %v", err)
+	}
+
+	// The attachment
+	p, err = mr.NextPart()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Header.Get("Content-Type"); got != "image/png" {
+		t.Errorf("attachment Content-Type = %q, want image/png", got)
+	}
+	if got := p.Header.Get("Content-Transfer-Encoding"); got != "base64" {
+		t.Errorf("attachment Content-Transfer-Encoding = %q, want base64", got)
+	}
+	disposition, _, _ := mime.ParseMediaType(p.Header.Get("Content-Disposition"))
+	if disposition != "attachment" {
+		t.Errorf("attachment Content-Disposition = %q, want attachment",
+			disposition)
+	}
+	encoded, _ := io.ReadAll(p)
+	for _, line := range strings.Split(strings.TrimSpace(string(encoded)), "\r\n") {
+		if len(line) > 76 {
+			t.Errorf("attachment line exceeds 76 characters: %q", line)
+		}
+	}
+	data, err := io.ReadAll(base64.NewDecoder(base64.StdEncoding,
+		strings.NewReader(strings.ReplaceAll(string(encoded), "\r\n", ""))))
+	if err != nil || bytes.Equal(data, attachment) == false {
+		t.Errorf("attachment = %x (%v), want %x", data, err, attachment)
+	}
+
+	if _, err := mr.NextPart(); err != io.EOF {
+		t.Errorf("expected two parts, got %v", err)
+	}
+}
+
+func TestLoadRejectsPlainAuthToRemoteHost(t *testing.T) {
+	args := map[string]interface{}{
+		"host":     "smtp.example.com",
+		"security": "none",
+		"username": "overpush",
+		"password": "hunter2",
+		"from":     "overpush@example.com",
+	}
+	if _, err := newTarget(t, args); err == nil {
+		t.Error("expected authentication without TLS to be rejected")
+	}
+
+	delete(args, "username")
+	if _, err := newTarget(t, args); err != nil {
+		t.Errorf("expected no authentication without TLS to be accepted: %v", err)
+	}
+}
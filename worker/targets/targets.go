@@ -6,8 +6,21 @@ import (
 	"github.com/mrusme/overpush/config"
 	"github.com/mrusme/overpush/helpers"
 	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/subscription"
 	"github.com/mrusme/overpush/models/target"
+	"github.com/mrusme/overpush/worker/targets/acknowledge"
 	"github.com/mrusme/overpush/worker/targets/apprise"
+	"github.com/mrusme/overpush/worker/targets/discord"
+	"github.com/mrusme/overpush/worker/targets/gotify"
+	"github.com/mrusme/overpush/worker/targets/matrix"
+	"github.com/mrusme/overpush/worker/targets/mqtt"
+	"github.com/mrusme/overpush/worker/targets/ntfy"
+	"github.com/mrusme/overpush/worker/targets/signal"
+	"github.com/mrusme/overpush/worker/targets/slack"
+	"github.com/mrusme/overpush/worker/targets/smtp"
+	"github.com/mrusme/overpush/worker/targets/telegram"
+	"github.com/mrusme/overpush/worker/targets/webhook"
+	"github.com/mrusme/overpush/worker/targets/webpush"
 	"github.com/mrusme/overpush/worker/targets/xmpp"
 	"go.uber.org/zap"
 )
@@ -22,6 +35,21 @@ type ITarget interface {
 	Shutdown() error
 }
 
+// IAcknowledgeable is implemented by targets that are able to receive
+// acknowledgements for emergency messages from their recipients.
+type IAcknowledgeable interface {
+	SetAcknowledger(ack *acknowledge.Acknowledger)
+}
+
+// ISubscribable is implemented by targets that deliver to subscriptions which
+// users register through the API, e.g. browsers subscribed to Web Push.
+type ISubscribable interface {
+	SetSubscriptionHandlers(
+		get func(userKey string, targetID string) ([]subscription.Subscription, error),
+		remove func(userKey string, id string) (bool, error),
+	)
+}
+
 type (
 	ITargets map[string]ITarget
 )
@@ -46,6 +74,28 @@ func NewTarget(
 		t, err = xmpp.New(cfg, log, targetCfg)
 	case "apprise":
 		t, err = apprise.New(cfg, log, targetCfg)
+	case "ntfy":
+		t, err = ntfy.New(cfg, log, targetCfg)
+	case "gotify":
+		t, err = gotify.New(cfg, log, targetCfg)
+	case "matrix":
+		t, err = matrix.New(cfg, log, targetCfg)
+	case "telegram":
+		t, err = telegram.New(cfg, log, targetCfg)
+	case "discord":
+		t, err = discord.New(cfg, log, targetCfg)
+	case "slack":
+		t, err = slack.New(cfg, log, targetCfg)
+	case "smtp":
+		t, err = smtp.New(cfg, log, targetCfg)
+	case "webhook":
+		t, err = webhook.New(cfg, log, targetCfg)
+	case "signal":
+		t, err = signal.New(cfg, log, targetCfg)
+	case "mqtt":
+		t, err = mqtt.New(cfg, log, targetCfg)
+	case "webpush":
+		t, err = webpush.New(cfg, log, targetCfg)
 	default:
 		return nil, errors.New("No such target type")
 	}
@@ -79,6 +129,25 @@ func New(
 	return ts, nil
 }
 
+func (ts *Targets) SetAcknowledger(ack *acknowledge.Acknowledger) {
+	for _, t := range ts.targets {
+		if at, ok := t.(IAcknowledgeable); ok {
+			at.SetAcknowledger(ack)
+		}
+	}
+}
+
+func (ts *Targets) SetSubscriptionHandlers(
+	get func(userKey string, targetID string) ([]subscription.Subscription, error),
+	remove func(userKey string, id string) (bool, error),
+) {
+	for _, t := range ts.targets {
+		if st, ok := t.(ISubscribable); ok {
+			st.SetSubscriptionHandlers(get, remove)
+		}
+	}
+}
+
 func (ts *Targets) LoadAll() error {
 	for _, tcfg := range ts.targetCfgs {
 		if err := ts.targets[tcfg.ID].Load(); err != nil {
@@ -110,24 +179,12 @@ func (ts *Targets) Execute(
 	m message.Message,
 	appArgs map[string]interface{},
 ) error {
-	return ts.targets[id].Execute(m, appArgs)
-}
-
-func (ts *Targets) ExecuteAll(
-	m message.Message,
-	appArgs map[string]interface{},
-) (bool, map[string]error) {
-	var errs map[string]error = make(map[string]error)
-	var ok bool = true
-
-	for _, tcfg := range ts.targetCfgs {
-		if err := ts.targets[tcfg.ID].Execute(m, appArgs); err != nil {
-			errs[tcfg.ID] = err
-			ok = false
-		}
+	t, ok := ts.targets[id]
+	if !ok {
+		return errors.New("No such target")
 	}
 
-	return ok, errs
+	return t.Execute(m, appArgs)
 }
 
 func (ts *Targets) ShutdownAll() (bool, helpers.Errors) {
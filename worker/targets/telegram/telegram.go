@@ -0,0 +1,410 @@
+package telegram
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"html"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT = 30 * time.Second
+	API_URL = "https://api.telegram.org"
+	// MAX_CAPTION_LENGTH is the maximum length of captions of photos and
+	// documents, longer texts are sent as separate message
+	MAX_CAPTION_LENGTH = 1024
+	// MIN_RETRY_AFTER is the delay used for rate limits that do not specify
+	// a (positive) `retry_after`
+	MIN_RETRY_AFTER = 1 * time.Second
+)
+
+// supportedTags are the HTML tags supported by Telegram's HTML parse mode, see
+// https://core.telegram.org/bots/api#html-style
+var supportedTags = map[string]bool{
+	"b": true, "strong": true, "i": true, "em": true, "u": true, "ins": true,
+	"s": true, "strike": true, "del": true, "span": true, "tg-spoiler": true,
+	"tg-emoji": true, "a": true, "code": true, "pre": true, "blockquote": true,
+}
+
+var tagRegexp = regexp.MustCompile(`<(/?)([a-zA-Z][a-zA-Z0-9-]*)[^>]*>`)
+
+// APIError is returned for unsuccessful responses of the Bot API.
+type APIError struct {
+	Method      string
+	ErrorCode   int
+	Description string
+}
+
+func (e *APIError) Error() string {
+	return fmt.Sprintf("Telegram %s returned %d: %s",
+		e.Method, e.ErrorCode, e.Description)
+}
+
+// isParseError reports whether Telegram failed to parse the formatting of the
+// text, e.g. because of unbalanced tags or unescaped MarkdownV2 characters.
+func isParseError(err error) bool {
+	var aerr *APIError
+	return errors.As(err, &aerr) &&
+		aerr.ErrorCode == http.StatusBadRequest &&
+		strings.Contains(strings.ToLower(aerr.Description), "can't parse entities")
+}
+
+type Telegram struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	apiURL   string
+	token    string
+	markdown bool
+	client   *http.Client
+}
+
+type response struct {
+	OK          bool   `json:"ok"`
+	ErrorCode   int    `json:"error_code"`
+	Description string `json:"description"`
+	Parameters  struct {
+		RetryAfter int `json:"retry_after"`
+	} `json:"parameters"`
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*Telegram, error) {
+	t := new(Telegram)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *Telegram) Load() error {
+	t.log.Info("Load target: Telegram")
+
+	var ok bool
+	t.token, ok = helpers.GetArg(t.targetCfg.Args, "token")
+	if !ok || t.token == "" {
+		return errors.New("Could not get Telegram bot token")
+	}
+
+	t.apiURL = API_URL
+	if apiURL, ok := helpers.GetArg(t.targetCfg.Args, "apiurl"); ok && apiURL != "" {
+		t.apiURL = strings.TrimSuffix(apiURL, "/")
+	}
+
+	if markdown, ok := helpers.GetArg(t.targetCfg.Args, "markdown"); ok {
+		t.markdown, _ = strconv.ParseBool(markdown)
+	}
+
+	t.client = &http.Client{Timeout: TIMEOUT}
+
+	return nil
+}
+
+func (t *Telegram) Run() error {
+	t.log.Info("Run target: Telegram")
+	return nil
+}
+
+// format returns the text and parse mode for the message. Messages with
+// `html=1` use Telegram's HTML parse mode, all others are sent as plain text,
+// unless the target is configured to use MarkdownV2.
+func (t *Telegram) format(m message.Message) (string, string) {
+	switch {
+	case m.HTML == 1:
+		if m.Title == "" {
+			return stripUnsupportedTags(m.Message), "HTML"
+		}
+		return fmt.Sprintf("<b>%s</b>\n%s", html.EscapeString(m.Title),
+			stripUnsupportedTags(m.Message)), "HTML"
+	case t.markdown == true:
+		if m.Title == "" {
+			return m.Message, "MarkdownV2"
+		}
+		return fmt.Sprintf("*%s*\n%s", escapeMarkdownV2(m.Title),
+			m.Message), "MarkdownV2"
+	}
+
+	if m.Title == "" {
+		return m.Message, ""
+	}
+	return fmt.Sprintf("%s\n\n%s", m.Title, m.Message), ""
+}
+
+// plain returns the message as plain text, for when Telegram fails to parse
+// its formatting.
+func plain(m message.Message) string {
+	text := m.Message
+	if m.HTML == 1 {
+		text = html.UnescapeString(tagRegexp.ReplaceAllStringFunc(text,
+			func(tag string) string {
+				if strings.ToLower(tagRegexp.FindStringSubmatch(tag)[2]) == "br" {
+					return "\n"
+				}
+				return ""
+			}))
+	}
+
+	if m.Title == "" {
+		return text
+	}
+	return fmt.Sprintf("%s\n\n%s", m.Title, text)
+}
+
+// stripUnsupportedTags removes HTML tags that Telegram does not support (e.g.
+// `<font>`), keeping their content, and converts line breaks (`<br>`).
+func stripUnsupportedTags(s string) string {
+	return tagRegexp.ReplaceAllStringFunc(s, func(tag string) string {
+		name := strings.ToLower(tagRegexp.FindStringSubmatch(tag)[2])
+		switch {
+		case supportedTags[name] == true:
+			return tag
+		case name == "br":
+			return "\n"
+		}
+		return ""
+	})
+}
+
+func escapeMarkdownV2(s string) string {
+	var b strings.Builder
+	for _, r := range s {
+		if strings.ContainsRune("_*[]()~`>#+-=|{}.!\\", r) {
+			b.WriteRune('\\')
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+func (t *Telegram) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	chatID, ok := helpers.GetArg(appArgs, "destination")
+	if !ok || chatID == "" {
+		return errors.New("Could not get Telegram chat ID")
+	}
+
+	text, parseMode := t.format(m)
+
+	params := map[string]string{
+		"chat_id": chatID,
+	}
+	if parseMode != "" {
+		params["parse_mode"] = parseMode
+	}
+	if m.Priority < 0 {
+		params["disable_notification"] = "true"
+	}
+	if m.URL != "" {
+		label := m.URLTitle
+		if label == "" {
+			label = m.URL
+		}
+		markup, err := json.Marshal(map[string]interface{}{
+			"inline_keyboard": [][]map[string]string{
+				{{"text": label, "url": m.URL}},
+			},
+		})
+		if err != nil {
+			return err
+		}
+		params["reply_markup"] = string(markup)
+	}
+
+	data, mimeType, err := m.GetAttachmentData()
+	if err != nil {
+		return err
+	}
+	attachmentURL := m.GetAttachmentURL()
+
+	if data == nil && attachmentURL == "" {
+		params["text"] = text
+		return t.send(m, "sendMessage", params, "", "", nil)
+	}
+
+	method, field := "sendDocument", "document"
+	if strings.HasPrefix(mimeType, "image/") ||
+		(data == nil && isImageURL(attachmentURL)) {
+		method, field = "sendPhoto", "photo"
+	}
+
+	var textSent bool = false
+	if len([]rune(text)) <= MAX_CAPTION_LENGTH {
+		params["caption"] = text
+	} else {
+		textParams := make(map[string]string)
+		for k, v := range params {
+			textParams[k] = v
+		}
+		textParams["text"] = text
+		delete(textParams, "reply_markup")
+		if err := t.send(m, "sendMessage", textParams, "", "", nil); err != nil {
+			return err
+		}
+		textSent = true
+		delete(params, "parse_mode")
+	}
+
+	if data == nil {
+		params[field] = attachmentURL
+		err = t.send(m, method, params, "", "", nil)
+	} else {
+		err = t.send(m, method, params, field,
+			m.GetAttachmentFilename(mimeType), data)
+	}
+	if err != nil && textSent == true {
+		// Retrying the delivery would send the text again
+		t.log.Error("Telegram failed to send attachment after sending text, "+
+			"not retrying",
+			zap.Error(err))
+		return target.Permanent(err)
+	}
+	return err
+}
+
+// send calls the Bot API method and, if Telegram fails to parse the text's
+// formatting, calls it once more with the message as plain text.
+func (t *Telegram) send(
+	m message.Message,
+	method string,
+	params map[string]string,
+	field string,
+	filename string,
+	data []byte,
+) error {
+	err := t.call(method, params, field, filename, data)
+	if params["parse_mode"] == "" || isParseError(err) == false {
+		return err
+	}
+
+	t.log.Warn("Telegram failed to parse formatting, sending plain text",
+		zap.String("method", method),
+		zap.Error(err))
+
+	plainParams := make(map[string]string)
+	for k, v := range params {
+		plainParams[k] = v
+	}
+	delete(plainParams, "parse_mode")
+	for _, k := range []string{"text", "caption"} {
+		if _, ok := plainParams[k]; ok {
+			plainParams[k] = plain(m)
+		}
+	}
+
+	return t.call(method, plainParams, field, filename, data)
+}
+
+func isImageURL(u string) bool {
+	u = strings.ToLower(strings.SplitN(u, "?", 2)[0])
+	for _, ext := range []string{".jpg", ".jpeg", ".png", ".gif", ".webp"} {
+		if strings.HasSuffix(u, ext) {
+			return true
+		}
+	}
+	return false
+}
+
+// call calls the Bot API method, uploading `data` as `field` if given. Rate
+// limits are returned as errors that ask to retry after the time requested by
+// Telegram, errors caused by the request (e.g. unknown chats or bots that
+// were blocked) as permanent errors.
+func (t *Telegram) call(
+	method string,
+	params map[string]string,
+	field string,
+	filename string,
+	data []byte,
+) error {
+	var body bytes.Buffer
+	w := multipart.NewWriter(&body)
+	for k, v := range params {
+		if err := w.WriteField(k, v); err != nil {
+			return err
+		}
+	}
+	if data != nil {
+		fw, err := w.CreateFormFile(field, filename)
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(data); err != nil {
+			return err
+		}
+	}
+	if err := w.Close(); err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest("POST",
+		fmt.Sprintf("%s/bot%s/%s", t.apiURL, t.token, method), &body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", w.FormDataContentType())
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		// Note: The error contains the URL, which contains the bot token
+		t.log.Error("Telegram failed to send",
+			zap.String("method", method))
+		return fmt.Errorf("Telegram %s failed", method)
+	}
+	defer resp.Body.Close()
+
+	var tresp response
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 64*1024))
+	if err := json.Unmarshal(respBody, &tresp); err != nil {
+		return fmt.Errorf("Telegram returned %s", resp.Status)
+	}
+	if tresp.OK == true {
+		t.log.Debug("Telegram successfully sent message",
+			zap.String("method", method),
+			zap.String("chat_id", params["chat_id"]))
+		return nil
+	}
+
+	err = &APIError{
+		Method:      method,
+		ErrorCode:   tresp.ErrorCode,
+		Description: tresp.Description,
+	}
+	switch {
+	case tresp.ErrorCode == http.StatusTooManyRequests:
+		return target.RetryAfter(err, max(
+			time.Duration(tresp.Parameters.RetryAfter)*time.Second,
+			MIN_RETRY_AFTER))
+	case tresp.ErrorCode == http.StatusBadRequest ||
+		tresp.ErrorCode == http.StatusUnauthorized ||
+		tresp.ErrorCode == http.StatusForbidden ||
+		tresp.ErrorCode == http.StatusNotFound:
+		return target.Permanent(err)
+	}
+	return err
+}
+
+func (t *Telegram) Shutdown() error {
+	t.log.Info("Shutdown target: Telegram")
+	return nil
+}
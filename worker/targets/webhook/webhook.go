@@ -0,0 +1,311 @@
+package webhook
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	DEFAULT_METHOD       = "POST"
+	DEFAULT_CONTENT_TYPE = "application/json"
+	DEFAULT_TIMEOUT      = 30
+	DEFAULT_HMAC_HEADER  = "X-Overpush-Signature"
+	// MIN_RETRY_AFTER is the delay used for `Retry-After` headers that do not
+	// specify a delay in the future
+	MIN_RETRY_AFTER = 1 * time.Second
+)
+
+type Webhook struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	url         string
+	method      string
+	body        string
+	contentType string
+	headers     map[string]string
+
+	hmacSecret    string
+	hmacHeader    string
+	hmacAlgorithm string
+
+	retryable []string
+	permanent []string
+
+	client *http.Client
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*Webhook, error) {
+	t := new(Webhook)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *Webhook) Load() error {
+	t.log.Info("Load target: Webhook")
+
+	var ok bool
+	t.url, ok = helpers.GetArg(t.targetCfg.Args, "url")
+	if !ok || t.url == "" {
+		return errors.New("Could not get webhook URL")
+	}
+
+	t.method = DEFAULT_METHOD
+	if method, ok := helpers.GetArg(t.targetCfg.Args, "method"); ok && method != "" {
+		t.method = method
+	}
+
+	t.body, _ = helpers.GetArg(t.targetCfg.Args, "body")
+
+	t.contentType = DEFAULT_CONTENT_TYPE
+	if contentType, ok := helpers.GetArg(t.targetCfg.Args, "contenttype"); ok {
+		t.contentType = contentType
+	}
+
+	t.headers = make(map[string]string)
+	if val, ok := t.targetCfg.Args["headers"]; ok {
+		headers, ok := val.(map[string]interface{})
+		if !ok {
+			return errors.New("Could not parse webhook headers")
+		}
+		for name := range headers {
+			t.headers[name], _ = helpers.GetArg(headers, name)
+		}
+	}
+
+	t.hmacSecret, _ = helpers.GetArg(t.targetCfg.Args, "hmacsecret")
+	t.hmacHeader = DEFAULT_HMAC_HEADER
+	if header, ok := helpers.GetArg(t.targetCfg.Args, "hmacheader"); ok && header != "" {
+		t.hmacHeader = header
+	}
+	t.hmacAlgorithm = "sha256"
+	if algorithm, ok := helpers.GetArg(t.targetCfg.Args, "hmacalgorithm"); ok && algorithm != "" {
+		t.hmacAlgorithm = strings.ToLower(algorithm)
+	}
+	if newHash(t.hmacAlgorithm) == nil {
+		return errors.New("Webhook HMAC algorithm must be one of sha1, sha256 or sha512")
+	}
+
+	timeout := DEFAULT_TIMEOUT
+	if val, ok := helpers.GetArg(t.targetCfg.Args, "timeout"); ok {
+		var err error
+		if timeout, err = strconv.Atoi(val); err != nil || timeout <= 0 {
+			return errors.New("Could not parse webhook timeout")
+		}
+	}
+	t.client = &http.Client{Timeout: time.Duration(timeout) * time.Second}
+
+	t.retryable = getStatusCodes(t.targetCfg.Args["retryable"])
+	t.permanent = getStatusCodes(t.targetCfg.Args["permanent"])
+
+	return nil
+}
+
+func (t *Webhook) Run() error {
+	t.log.Info("Run target: Webhook")
+	return nil
+}
+
+func newHash(algorithm string) func() hash.Hash {
+	switch algorithm {
+	case "sha1":
+		return sha1.New
+	case "sha256":
+		return sha256.New
+	case "sha512":
+		return sha512.New
+	}
+	return nil
+}
+
+// getStatusCodes returns the status codes (e.g. "404") and classes (e.g.
+// "5xx") from a list or a comma-separated string.
+func getStatusCodes(val interface{}) []string {
+	var codes []string
+
+	switch casted := val.(type) {
+	case string:
+		codes = strings.Split(casted, ",")
+	case []interface{}:
+		for _, v := range casted {
+			codes = append(codes, fmt.Sprint(v))
+		}
+	}
+
+	for i := range codes {
+		codes[i] = strings.ToLower(strings.TrimSpace(codes[i]))
+	}
+
+	return codes
+}
+
+func matchesStatus(codes []string, status int) bool {
+	code := strconv.Itoa(status)
+	for _, c := range codes {
+		if c == code || (len(c) == 3 && strings.HasSuffix(c, "xx") &&
+			c[0] == code[0]) {
+			return true
+		}
+	}
+	return false
+}
+
+// isPermanent reports whether the response status should not be retried. By
+// default, client errors are permanent, except for timeouts and rate limits.
+func (t *Webhook) isPermanent(status int) bool {
+	if matchesStatus(t.permanent, status) {
+		return true
+	}
+	if matchesStatus(t.retryable, status) {
+		return false
+	}
+	return status >= 400 && status <= 499 &&
+		status != http.StatusRequestTimeout &&
+		status != http.StatusTooEarly &&
+		status != http.StatusTooManyRequests
+}
+
+// defaultBody returns the JSON body that is sent if no body template is
+// configured.
+func defaultBody(m message.Message) (string, error) {
+	b, err := json.Marshal(map[string]interface{}{
+		"title":             m.Title,
+		"message":           m.Message,
+		"html":              m.HTML,
+		"priority":          m.Priority,
+		"timestamp":         m.GetTime().Unix(),
+		"url":               m.URL,
+		"url_title":         m.URLTitle,
+		"attachment":        m.Attachment,
+		"attachment_base64": m.AttachmentBase64,
+		"attachment_type":   m.AttachmentType,
+		"tags":              m.GetTags(),
+		"receipt":           m.GetReceipt(),
+	})
+	return string(b), err
+}
+
+func (t *Webhook) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	url, err := helpers.RenderTemplate(t.url, appArgs, m)
+	if err != nil {
+		return target.Permanent(err)
+	}
+	method, err := helpers.RenderTemplate(t.method, appArgs, m)
+	if err != nil {
+		return target.Permanent(err)
+	}
+
+	var body string
+	if t.body == "" {
+		body, err = defaultBody(m)
+	} else {
+		body, err = helpers.RenderTemplate(t.body, appArgs, m)
+	}
+	if err != nil {
+		return target.Permanent(err)
+	}
+
+	req, err := http.NewRequest(strings.ToUpper(method), url,
+		bytes.NewReader([]byte(body)))
+	if err != nil {
+		return target.Permanent(err)
+	}
+	if t.contentType != "" {
+		req.Header.Set("Content-Type", t.contentType)
+	}
+	for name, tmpl := range t.headers {
+		val, err := helpers.RenderTemplate(tmpl, appArgs, m)
+		if err != nil {
+			return target.Permanent(err)
+		}
+		req.Header.Set(name, val)
+	}
+
+	if t.hmacSecret != "" {
+		mac := hmac.New(newHash(t.hmacAlgorithm), []byte(t.hmacSecret))
+		mac.Write([]byte(body))
+		req.Header.Set(t.hmacHeader,
+			t.hmacAlgorithm+"="+hex.EncodeToString(mac.Sum(nil)))
+	}
+
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		t.log.Debug("Webhook successfully sent message",
+			zap.Int("StatusCode", resp.StatusCode))
+		return nil
+	}
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	err = fmt.Errorf("Webhook returned %s: %s",
+		resp.Status, strings.TrimSpace(string(respBody)))
+
+	if t.isPermanent(resp.StatusCode) == true {
+		return target.Permanent(err)
+	}
+	if retryAfter, ok := parseRetryAfter(resp.Header.Get("Retry-After"),
+		time.Now()); ok == true {
+		return target.RetryAfter(err, retryAfter)
+	}
+	return err
+}
+
+// parseRetryAfter returns the delay of a `Retry-After` header, which is either
+// a number of seconds or an HTTP date, but at least MIN_RETRY_AFTER.
+func parseRetryAfter(val string, now time.Time) (time.Duration, bool) {
+	val = strings.TrimSpace(val)
+	if val == "" {
+		return 0, false
+	}
+
+	var retryAfter time.Duration
+	if seconds, err := strconv.Atoi(val); err == nil {
+		retryAfter = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(val); err == nil {
+		retryAfter = date.Sub(now)
+	} else {
+		return 0, false
+	}
+
+	return max(retryAfter, MIN_RETRY_AFTER), true
+}
+
+func (t *Webhook) Shutdown() error {
+	t.log.Info("Shutdown target: Webhook")
+	return nil
+}
@@ -0,0 +1,82 @@
+package webhook
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+func TestParseRetryAfter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	for _, tc := range []struct {
+		val  string
+		want time.Duration
+		ok   bool
+	}{
+		{"", 0, false},
+		{"soon", 0, false},
+		{"120", 2 * time.Minute, true},
+		{"0", MIN_RETRY_AFTER, true},
+		{"-5", MIN_RETRY_AFTER, true},
+		{"Mon, 01 Jan 2024 12:00:30 GMT", 30 * time.Second, true},
+		{"Mon, 01 Jan 2024 11:59:00 GMT", MIN_RETRY_AFTER, true},
+	} {
+		got, ok := parseRetryAfter(tc.val, now)
+		if got != tc.want || ok != tc.ok {
+			t.Errorf("parseRetryAfter(%q) = %v, %v, want %v, %v",
+				tc.val, got, ok, tc.want, tc.ok)
+		}
+	}
+}
+
+func TestExecuteRetryAfter(t *testing.T) {
+	for _, tc := range []struct {
+		status     int
+		retryAfter string
+		min        time.Duration
+		max        time.Duration
+	}{
+		{http.StatusTooManyRequests, "0", MIN_RETRY_AFTER, MIN_RETRY_AFTER},
+		{http.StatusServiceUnavailable, "30", 30 * time.Second, 30 * time.Second},
+		{http.StatusServiceUnavailable,
+			time.Now().Add(time.Minute).UTC().Format(http.TimeFormat),
+			50 * time.Second, time.Minute},
+	} {
+		srv := httptest.NewServer(http.HandlerFunc(
+			func(w http.ResponseWriter, r *http.Request) {
+				w.Header().Set("Retry-After", tc.retryAfter)
+				w.WriteHeader(tc.status)
+			}))
+
+		w, err := New(nil, zap.NewNop(), target.Target{
+			Enable: true,
+			ID:     "webhook",
+			Type:   "webhook",
+			Args:   map[string]interface{}{"url": srv.URL},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := w.Load(); err != nil {
+			t.Fatal(err)
+		}
+
+		err = w.Execute(message.Message{Message: "hello"}, nil)
+		srv.Close()
+		if err == nil || target.IsPermanent(err) == true {
+			t.Fatalf("Retry-After %q: err = %v, want a retryable error",
+				tc.retryAfter, err)
+		}
+		if d, ok := target.GetRetryAfter(err); ok == false ||
+			d < tc.min || d > tc.max {
+			t.Errorf("Retry-After %q: delay = %v, want between %v and %v",
+				tc.retryAfter, d, tc.min, tc.max)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package webpush
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/url"
+	"strings"
+	"syscall"
+
+	"github.com/mrusme/overpush/models/target"
+)
+
+// defaultPushServices are the domains of the browser vendors' push services,
+// which subscription endpoints must belong to unless the target configures
+// `pushservices`
+var defaultPushServices = []string{
+	"fcm.googleapis.com",        // Chrome, Chromium-based browsers
+	"push.services.mozilla.com", // Firefox
+	"push.apple.com",            // Safari
+	"notify.windows.com",        // Edge
+}
+
+var ErrPushServiceNotAllowed = errors.New(
+	"Subscription endpoint is not an allowed push service")
+
+// getPushServices returns the domains of the push services that the target
+// accepts subscriptions for. The domain "*" allows any host that only
+// resolves to public addresses.
+func getPushServices(tgt target.Target) ([]string, error) {
+	var strs []string
+
+	switch casted := tgt.Args["pushservices"].(type) {
+	case nil:
+		return defaultPushServices, nil
+	case string:
+		strs = strings.Split(casted, ",")
+	case []interface{}:
+		for _, v := range casted {
+			strs = append(strs, fmt.Sprint(v))
+		}
+	case []string:
+		strs = casted
+	default:
+		return nil, errors.New("Could not parse Web Push push services")
+	}
+
+	var services []string
+	for _, s := range strs {
+		s = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(s)), "*.")
+		if s != "" {
+			services = append(services, strings.TrimPrefix(s, "."))
+		}
+	}
+	if len(services) == 0 {
+		return defaultPushServices, nil
+	}
+
+	return services, nil
+}
+
+// isPushService reports whether host is one of the push service domains or a
+// subdomain of one.
+func isPushService(host string, services []string) bool {
+	host = strings.TrimSuffix(strings.ToLower(host), ".")
+	for _, s := range services {
+		if host == s || strings.HasSuffix(host, "."+s) {
+			return true
+		}
+	}
+	return false
+}
+
+func allowsAnyHost(services []string) bool {
+	for _, s := range services {
+		if s == "*" {
+			return true
+		}
+	}
+	return false
+}
+
+// isPublicIP reports whether ip is a public unicast address, as opposed to
+// e.g. loopback, private or link-local addresses.
+func isPublicIP(ip net.IP) bool {
+	return ip.IsGlobalUnicast() == true && ip.IsPrivate() == false
+}
+
+// checkHost returns an error unless the host belongs to one of the push
+// services or, if any host is allowed, is not a non-public IP address.
+func checkHost(host string, services []string) error {
+	if isPushService(host, services) == true {
+		return nil
+	}
+	if allowsAnyHost(services) == false {
+		return ErrPushServiceNotAllowed
+	}
+	if ip := net.ParseIP(host); ip != nil && isPublicIP(ip) == false {
+		return ErrPushServiceNotAllowed
+	}
+	return nil
+}
+
+// CheckEndpoint returns an error unless the subscription endpoint belongs to
+// one of the target's push services. Hosts that are only allowed by "*" must
+// resolve to public addresses only.
+func CheckEndpoint(tgt target.Target, endpoint string) error {
+	services, err := getPushServices(tgt)
+	if err != nil {
+		return err
+	}
+
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return err
+	}
+	host := u.Hostname()
+	if err := checkHost(host, services); err != nil {
+		return err
+	}
+	if isPushService(host, services) == true {
+		return nil
+	}
+
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return fmt.Errorf("Could not resolve subscription endpoint: %w", err)
+	}
+	for _, ip := range ips {
+		if isPublicIP(ip) == false {
+			return ErrPushServiceNotAllowed
+		}
+	}
+
+	return nil
+}
+
+// dialContext connects to the push service. Connections to hosts that are
+// only allowed by "*" are refused for non-public addresses at the time of
+// dialing, so that changed DNS records cannot redirect requests to internal
+// services.
+func (t *WebPush) dialContext(
+	ctx context.Context,
+	network string,
+	addr string,
+) (net.Conn, error) {
+	dialer := &net.Dialer{Timeout: TIMEOUT}
+
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if isPushService(host, t.pushServices) == false {
+		dialer.Control = func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || isPublicIP(ip) == false {
+				return ErrPushServiceNotAllowed
+			}
+			return nil
+		}
+	}
+
+	return dialer.DialContext(ctx, network, addr)
+}
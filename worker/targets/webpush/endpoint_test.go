@@ -0,0 +1,45 @@
+package webpush
+
+import (
+	"testing"
+
+	"github.com/mrusme/overpush/models/target"
+)
+
+func TestCheckHost(t *testing.T) {
+	for _, tc := range []struct {
+		pushServices interface{}
+		host         string
+		allowed      bool
+	}{
+		{nil, "fcm.googleapis.com", true},
+		{nil, "updates.push.services.mozilla.com", true},
+		{nil, "web.push.apple.com", true},
+		{nil, "evilpush.apple.com", false},
+		{nil, "push.example.com", false},
+		{nil, "127.0.0.1", false},
+		{"push.example.com", "push.example.com", true},
+		{"push.example.com", "fcm.googleapis.com", false},
+		{[]interface{}{"*.example.com"}, "push.example.com", true},
+		{[]interface{}{"*"}, "push.example.com", true},
+		{[]interface{}{"*"}, "8.8.8.8", true},
+		{[]interface{}{"*"}, "127.0.0.1", false},
+		{[]interface{}{"*"}, "10.0.0.1", false},
+		{[]interface{}{"*"}, "169.254.169.254", false},
+		{[]interface{}{"*"}, "::1", false},
+		{[]interface{}{"*"}, "fe80::1", false},
+		{[]interface{}{"*"}, "0.0.0.0", false},
+	} {
+		services, err := getPushServices(target.Target{
+			Args: map[string]interface{}{"pushservices": tc.pushServices},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = checkHost(tc.host, services)
+		if (err == nil) != tc.allowed {
+			t.Errorf("checkHost(%q, %v) = %v, want allowed = %v",
+				tc.host, services, err, tc.allowed)
+		}
+	}
+}
@@ -0,0 +1,23 @@
+package webpush
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"encoding/base64"
+	"strings"
+)
+
+func decodeBase64URL(s string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(s, "="))
+}
+
+// parseVAPIDKey parses the base64url-encoded raw P-256 private key and derives
+// the public key from it.
+func parseVAPIDKey(privateKey string) (*ecdsa.PrivateKey, error) {
+	raw, err := decodeBase64URL(privateKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return ecdsa.ParseRawPrivateKey(elliptic.P256(), raw)
+}
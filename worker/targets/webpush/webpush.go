@@ -0,0 +1,362 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	webpushgo "github.com/SherClockHolmes/webpush-go"
+	"github.com/mrusme/overpush/config"
+	"github.com/mrusme/overpush/helpers"
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/subscription"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+const (
+	TIMEOUT = 30 * time.Second
+	// DEFAULT_TTL is the time push services keep messages for offline browsers,
+	// unless the message specifies a TTL
+	DEFAULT_TTL = 7 * 24 * time.Hour
+	// RECORD_SIZE is the record size of the encrypted content, which is sent as a
+	// single record; push services must support at least 4096 bytes
+	RECORD_SIZE = 4096
+	// MAX_PAYLOAD_SIZE is the maximum size of the plaintext payload, which is
+	// the record size minus the header (salt, record size, key ID length and
+	// key ID), the padding delimiter and the AEAD tag
+	MAX_PAYLOAD_SIZE = RECORD_SIZE - (16 + 4 + 1 + 65) - 1 - 16
+)
+
+type WebPush struct {
+	cfg       *config.Config
+	log       *zap.Logger
+	targetCfg target.Target
+
+	privateKey   string
+	publicKey    string
+	subject      string
+	pushServices []string
+	client       *http.Client
+
+	getSubscriptions   func(userKey string, targetID string) ([]subscription.Subscription, error)
+	removeSubscription func(userKey string, id string) (bool, error)
+}
+
+type payload struct {
+	Title      string   `json:"title"`
+	Message    string   `json:"message"`
+	HTML       bool     `json:"html"`
+	Priority   int      `json:"priority"`
+	Timestamp  int64    `json:"timestamp"`
+	URL        string   `json:"url,omitempty"`
+	URLTitle   string   `json:"url_title,omitempty"`
+	Attachment string   `json:"attachment,omitempty"`
+	Tags       []string `json:"tags,omitempty"`
+	Receipt    string   `json:"receipt,omitempty"`
+}
+
+func New(
+	cfg *config.Config,
+	log *zap.Logger,
+	targetCfg target.Target,
+) (*WebPush, error) {
+	t := new(WebPush)
+
+	t.cfg = cfg
+	t.log = log
+	t.targetCfg = targetCfg
+
+	return t, nil
+}
+
+func (t *WebPush) SetSubscriptionHandlers(
+	get func(userKey string, targetID string) ([]subscription.Subscription, error),
+	remove func(userKey string, id string) (bool, error),
+) {
+	t.getSubscriptions = get
+	t.removeSubscription = remove
+}
+
+func (t *WebPush) Load() error {
+	t.log.Info("Load target: WebPush")
+
+	key, pub, err := getKeys(t.targetCfg)
+	if err != nil {
+		return err
+	}
+	privateKey, err := key.Bytes()
+	if err != nil {
+		return err
+	}
+	t.privateKey = base64.RawURLEncoding.EncodeToString(privateKey)
+	t.publicKey = base64.RawURLEncoding.EncodeToString(pub)
+
+	var ok bool
+	t.subject, ok = helpers.GetArg(t.targetCfg.Args, "subject")
+	if !ok || (strings.HasPrefix(t.subject, "mailto:") == false &&
+		strings.HasPrefix(t.subject, "https://") == false) {
+		return errors.New("Web Push VAPID subject must be a mailto: or https:// URL")
+	}
+
+	if t.pushServices, err = getPushServices(t.targetCfg); err != nil {
+		return err
+	}
+
+	// Requests are not proxied, as the addresses of proxied requests could not
+	// be checked
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.Proxy = nil
+	transport.DialContext = t.dialContext
+	t.client = &http.Client{Timeout: TIMEOUT, Transport: transport}
+
+	return nil
+}
+
+func (t *WebPush) Run() error {
+	t.log.Info("Run target: WebPush")
+	return nil
+}
+
+// getKeys returns the target's VAPID private key and the raw public key.
+func getKeys(tgt target.Target) (*ecdsa.PrivateKey, []byte, error) {
+	privateKey, ok := helpers.GetArg(tgt.Args, "privatekey")
+	if !ok || privateKey == "" {
+		return nil, nil, errors.New("Could not get Web Push VAPID private key")
+	}
+
+	key, err := parseVAPIDKey(privateKey)
+	if err != nil {
+		return nil, nil,
+			fmt.Errorf("Could not parse Web Push VAPID private key: %w", err)
+	}
+	pub, err := key.PublicKey.Bytes()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if publicKey, ok := helpers.GetArg(tgt.Args, "publickey"); ok &&
+		publicKey != "" {
+		raw, err := decodeBase64URL(publicKey)
+		if err != nil || bytes.Equal(raw, pub) == false {
+			return nil, nil,
+				errors.New("Web Push VAPID public key does not match private key")
+		}
+	}
+
+	return key, pub, nil
+}
+
+// GetPublicKey returns the base64url-encoded VAPID public key of the target,
+// which browsers require as `applicationServerKey` to subscribe.
+func GetPublicKey(tgt target.Target) (string, error) {
+	_, pub, err := getKeys(tgt)
+	if err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(pub), nil
+}
+
+func urgency(priority int) webpushgo.Urgency {
+	switch {
+	case priority <= -2:
+		return webpushgo.UrgencyVeryLow
+	case priority == -1:
+		return webpushgo.UrgencyLow
+	case priority >= 1:
+		return webpushgo.UrgencyHigh
+	}
+	return webpushgo.UrgencyNormal
+}
+
+// getPayload returns the JSON payload for the message, shortening the message
+// if it would not fit into a single push message.
+func getPayload(m message.Message) ([]byte, error) {
+	p := payload{
+		Title:      m.Title,
+		Message:    m.Message,
+		HTML:       m.HTML == 1,
+		Priority:   m.Priority,
+		Timestamp:  m.GetTime().Unix(),
+		URL:        m.URL,
+		URLTitle:   m.URLTitle,
+		Attachment: m.GetAttachmentURL(),
+		Tags:       m.GetTags(),
+		Receipt:    m.GetReceipt(),
+	}
+
+	for {
+		data, err := json.Marshal(p)
+		if err != nil || len(data) <= MAX_PAYLOAD_SIZE {
+			return data, err
+		}
+
+		runes := []rune(p.Message)
+		if len(runes) == 0 {
+			return nil, errors.New("Web Push payload too large")
+		}
+		excess := len(data) - MAX_PAYLOAD_SIZE
+		p.Message = helpers.Truncate(p.Message,
+			max(len(runes)-max(excess/4, 1)-1, 0))
+	}
+}
+
+// send delivers the payload to a single subscription and returns the response
+// status.
+func (t *WebPush) send(
+	s subscription.Subscription,
+	data []byte,
+	m message.Message,
+) (int, time.Duration, error) {
+	uaPublic, authSecret, err := s.GetKeys()
+	if err != nil {
+		return 0, 0, target.Permanent(err)
+	}
+
+	// Subscriptions might have been registered before the push services changed
+	u, err := url.Parse(s.Endpoint)
+	if err != nil {
+		return 0, 0, target.Permanent(err)
+	}
+	if err := checkHost(u.Hostname(), t.pushServices); err != nil {
+		return 0, 0, target.Permanent(err)
+	}
+
+	ttl := DEFAULT_TTL
+	if m.TTL > 0 {
+		ttl = time.Duration(m.TTL) * time.Second
+	}
+
+	// The payload is encrypted according to RFC 8291 and the request is
+	// authorized according to RFC 8292. The subscriber is prefixed with
+	// `mailto:` unless it is an https:// URL.
+	resp, err := webpushgo.SendNotification(data, &webpushgo.Subscription{
+		Endpoint: s.Endpoint,
+		Keys: webpushgo.Keys{
+			P256dh: base64.RawURLEncoding.EncodeToString(uaPublic),
+			Auth:   base64.RawURLEncoding.EncodeToString(authSecret),
+		},
+	}, &webpushgo.Options{
+		HTTPClient:      t.client,
+		RecordSize:      RECORD_SIZE,
+		Subscriber:      strings.TrimPrefix(t.subject, "mailto:"),
+		TTL:             int(ttl.Seconds()),
+		Urgency:         urgency(m.Priority),
+		VAPIDPublicKey:  t.publicKey,
+		VAPIDPrivateKey: t.privateKey,
+	})
+	if errors.Is(err, webpushgo.ErrMaxPadExceeded) == true {
+		return 0, 0, target.Permanent(err)
+	} else if err != nil {
+		return 0, 0, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 200 && resp.StatusCode <= 299 {
+		return resp.StatusCode, 0, nil
+	}
+
+	retryAfter, _ := strconv.Atoi(resp.Header.Get("Retry-After"))
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+	return resp.StatusCode, time.Duration(retryAfter) * time.Second,
+		fmt.Errorf("Web Push service returned %s: %s",
+			resp.Status, strings.TrimSpace(string(respBody)))
+}
+
+func (t *WebPush) Execute(
+	m message.Message,
+	appArgs map[string]interface{},
+) error {
+	if t.getSubscriptions == nil {
+		return errors.New("Web Push subscriptions not available")
+	}
+
+	subscriptions, err := t.getSubscriptions(m.User, t.targetCfg.ID)
+	if err != nil {
+		return err
+	}
+
+	// The subscription name allows addressing a single browser, e.g. as device
+	if name, ok := helpers.GetArg(appArgs, "subscription"); ok && name != "" {
+		var named []subscription.Subscription
+		for _, s := range subscriptions {
+			if strings.EqualFold(s.Name, name) {
+				named = append(named, s)
+			}
+		}
+		subscriptions = named
+	}
+
+	data, err := getPayload(m)
+	if err != nil {
+		return target.Permanent(err)
+	}
+
+	var sent int = 0
+	var retryAfter time.Duration
+	var lastErr error
+	var retryable bool = false
+	for _, s := range subscriptions {
+		status, delay, err := t.send(s, data, m)
+		if err == nil {
+			sent++
+			continue
+		}
+
+		t.log.Debug("WebPush failed to send",
+			zap.String("Subscription.ID", s.ID),
+			zap.Error(err))
+		lastErr = err
+
+		switch {
+		case target.IsPermanent(err) == true:
+			// e.g. the subscription's keys are invalid
+		case status == http.StatusNotFound || status == http.StatusGone:
+			// The subscription expired or the browser unsubscribed
+			t.log.Info("WebPush removing expired subscription",
+				zap.String("Subscription.ID", s.ID))
+			if _, err := t.removeSubscription(m.User, s.ID); err != nil {
+				t.log.Error("WebPush failed to remove subscription",
+					zap.String("Subscription.ID", s.ID),
+					zap.Error(err))
+			}
+		case status == 0 || status == http.StatusTooManyRequests ||
+			status >= 500:
+			retryable = true
+			retryAfter = max(retryAfter, delay)
+		}
+	}
+
+	// Retrying would deliver the message again to the browsers that received
+	// it, hence a delivery to at least one browser counts as success.
+	if sent > 0 {
+		t.log.Debug("WebPush successfully sent message",
+			zap.Int("Sent", sent),
+			zap.Int("Subscriptions", len(subscriptions)))
+		return nil
+	}
+
+	switch {
+	case len(subscriptions) == 0:
+		return target.Permanent(errors.New("No Web Push subscriptions"))
+	case retryable == false:
+		return target.Permanent(lastErr)
+	case retryAfter > 0:
+		return target.RetryAfter(lastErr, retryAfter)
+	}
+	return lastErr
+}
+
+func (t *WebPush) Shutdown() error {
+	t.log.Info("Shutdown target: WebPush")
+	return nil
+}
@@ -0,0 +1,276 @@
+package webpush
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/ecdh"
+	"crypto/ecdsa"
+	"crypto/hkdf"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mrusme/overpush/models/message"
+	"github.com/mrusme/overpush/models/subscription"
+	"github.com/mrusme/overpush/models/target"
+	"go.uber.org/zap"
+)
+
+// Keys of the example of RFC 8291, Appendix A
+const (
+	asPrivate  = "yfWPiYE-n46HLnH0KqZOF1fJJU3MYrct3AELtAQ-oRw"
+	asPublic   = "BP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A8"
+	uaPrivate  = "q1dXpw3UpT5VOmu_cf_v6ih07Aems3njxI-JWgLcM94"
+	uaPublic   = "BCVxsr7N_eNgVRqvHtD0zTZsEc6-VV-JvLexhqUzORcxaOzi6-AYWXvTBHm4bjyPjs7Vd8pZGH6SRpkNtoIAiw4"
+	authSecret = "BTBZMqHH6r4Tts7J_aSIgg"
+)
+
+func mustDecode(t *testing.T, s string) []byte {
+	t.Helper()
+
+	b, err := decodeBase64URL(s)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return b
+}
+
+// decrypt decrypts an aes128gcm body, consisting of a single record, as the
+// user agent does according to RFC 8291.
+func decrypt(t *testing.T, body []byte) ([]byte, error) {
+	t.Helper()
+
+	if len(body) < 21 || len(body) < 21+int(body[20]) {
+		return nil, errors.New("body too short")
+	}
+	salt := body[:16]
+	rs := binary.BigEndian.Uint32(body[16:20])
+	keyID := body[21 : 21+int(body[20])]
+	ciphertext := body[21+int(body[20]):]
+	if uint32(len(body)) > rs {
+		return nil, errors.New("body exceeds a single record")
+	}
+
+	key, err := ecdh.P256().NewPrivateKey(mustDecode(t, uaPrivate))
+	if err != nil {
+		return nil, err
+	}
+	asKey, err := ecdh.P256().NewPublicKey(keyID)
+	if err != nil {
+		return nil, err
+	}
+	secret, err := key.ECDH(asKey)
+	if err != nil {
+		return nil, err
+	}
+
+	info := append([]byte("WebPush: info\x00"), key.PublicKey().Bytes()...)
+	ikm, err := hkdf.Key(sha256.New, secret, mustDecode(t, authSecret),
+		string(append(info, keyID...)), 32)
+	if err != nil {
+		return nil, err
+	}
+	cek, err := hkdf.Key(sha256.New, ikm, salt,
+		"Content-Encoding: aes128gcm\x00", 16)
+	if err != nil {
+		return nil, err
+	}
+	nonce, err := hkdf.Key(sha256.New, ikm, salt,
+		"Content-Encoding: nonce\x00", 12)
+	if err != nil {
+		return nil, err
+	}
+
+	block, err := aes.NewCipher(cek)
+	if err != nil {
+		return nil, err
+	}
+	gcm, err := cipher.NewGCM(block)
+	if err != nil {
+		return nil, err
+	}
+	plaintext, err := gcm.Open(nil, nonce, ciphertext, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	// The last record ends with the delimiter 0x02, followed by the padding
+	plaintext = bytes.TrimRight(plaintext, "\x00")
+	if bytes.HasSuffix(plaintext, []byte{2}) == false {
+		return nil, errors.New("missing padding delimiter")
+	}
+	return plaintext[:len(plaintext)-1], nil
+}
+
+// TestDecrypt checks the decryption used by the tests against the example of
+// RFC 8291, Appendix A.
+func TestDecrypt(t *testing.T) {
+	body := mustDecode(t, "DGv6ra1nlYgDCS1FRnbzlwAAEABBBP4z9KsN6nGRTbVYI_c7VJSPQTBtkgcy27mlmlMoZIIgDll6e3vCYLocInmYWAmS6TlzAC8wEqKK6PBru3jl7A_yl95bQpu6cVPTpK4Mqgkf1CXztLVBSt2Ks3oZwbuwXPXLWyouBWLVWGNWQexSgSxsj_Qulcy4a-fN")
+
+	plaintext, err := decrypt(t, body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "When I grow up, I want to be a watermelon"; string(plaintext) != want {
+		t.Errorf("decrypt = %q, want %q", plaintext, want)
+	}
+}
+
+// checkAuthorization checks that the VAPID JWT is signed by the key of RFC
+// 8291, Appendix A and carries the claims required by RFC 8292.
+func checkAuthorization(t *testing.T, authorization string, aud string) {
+	t.Helper()
+
+	if strings.HasPrefix(authorization, "vapid ") == false {
+		t.Fatalf("Authorization %q does not use the vapid scheme", authorization)
+	}
+	var jwt, k string
+	for _, param := range strings.Split(
+		strings.TrimPrefix(authorization, "vapid "), ", ") {
+		name, value, _ := strings.Cut(param, "=")
+		switch name {
+		case "t":
+			jwt = value
+		case "k":
+			k = value
+		}
+	}
+	if k != asPublic {
+		t.Errorf("k = %s, want the public key of the VAPID key", k)
+	}
+
+	parts := strings.Split(jwt, ".")
+	if len(parts) != 3 {
+		t.Fatalf("JWT %q does not consist of three parts", jwt)
+	}
+
+	key, err := parseVAPIDKey(asPrivate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := mustDecode(t, parts[2])
+	if len(sig) != 64 {
+		t.Fatalf("JWT signature has %d bytes, want 64", len(sig))
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	if ecdsa.Verify(&key.PublicKey, digest[:],
+		new(big.Int).SetBytes(sig[:32]),
+		new(big.Int).SetBytes(sig[32:])) == false {
+		t.Error("JWT signature does not verify")
+	}
+
+	var header map[string]string
+	if err := json.Unmarshal(mustDecode(t, parts[0]), &header); err != nil {
+		t.Fatal(err)
+	}
+	if header["alg"] != "ES256" || header["typ"] != "JWT" {
+		t.Errorf("JWT header = %v, want ES256 JWT", header)
+	}
+
+	var claims struct {
+		Aud string `json:"aud"`
+		Exp int64  `json:"exp"`
+		Sub string `json:"sub"`
+	}
+	if err := json.Unmarshal(mustDecode(t, parts[1]), &claims); err != nil {
+		t.Fatal(err)
+	}
+	if claims.Aud != aud {
+		t.Errorf("aud = %q, want %q", claims.Aud, aud)
+	}
+	if claims.Sub != "mailto:admin@example.com" {
+		t.Errorf("sub = %q, want mailto:admin@example.com", claims.Sub)
+	}
+	if exp := time.Unix(claims.Exp, 0); exp.Before(time.Now()) ||
+		exp.After(time.Now().Add(24*time.Hour)) {
+		t.Errorf("exp = %v, want within the next 24h", exp)
+	}
+}
+
+func TestSend(t *testing.T) {
+	var req *http.Request
+	var body []byte
+	srv := httptest.NewServer(http.HandlerFunc(
+		func(w http.ResponseWriter, r *http.Request) {
+			req = r
+			body, _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		}))
+	defer srv.Close()
+
+	wp, err := New(nil, zap.NewNop(), target.Target{
+		Enable: true,
+		ID:     "webpush",
+		Type:   "webpush",
+		Args: map[string]interface{}{
+			"privatekey":   asPrivate,
+			"publickey":    asPublic,
+			"subject":      "mailto:admin@example.com",
+			"pushservices": "127.0.0.1",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := wp.Load(); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range []int{41, MAX_PAYLOAD_SIZE} {
+		data := bytes.Repeat([]byte("a"), size)
+		status, _, err := wp.send(subscription.Subscription{
+			Endpoint: srv.URL + "/push/1",
+			P256dh:   uaPublic,
+			Auth:     authSecret,
+		}, data, message.Message{Priority: 1, TTL: 60})
+		if err != nil || status != http.StatusCreated {
+			t.Fatalf("send = %d, %v", status, err)
+		}
+
+		if len(body) != RECORD_SIZE {
+			t.Errorf("body has %d bytes, want a single record of %d bytes",
+				len(body), RECORD_SIZE)
+		}
+		plaintext, err := decrypt(t, body)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if bytes.Equal(plaintext, data) == false {
+			t.Errorf("decrypted payload of %d bytes, want %d bytes",
+				len(plaintext), len(data))
+		}
+
+		for name, want := range map[string]string{
+			"Content-Encoding": "aes128gcm",
+			"TTL":              "60",
+			"Urgency":          "high",
+		} {
+			if got := req.Header.Get(name); got != want {
+				t.Errorf("%s = %q, want %q", name, got, want)
+			}
+		}
+		checkAuthorization(t, req.Header.Get("Authorization"), srv.URL)
+	}
+}
+
+func TestGetPayloadTooLarge(t *testing.T) {
+	data, err := getPayload(message.Message{
+		Message: strings.Repeat("a", 2*MAX_PAYLOAD_SIZE),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(data) > MAX_PAYLOAD_SIZE {
+		t.Errorf("payload has %d bytes, want at most %d",
+			len(data), MAX_PAYLOAD_SIZE)
+	}
+}
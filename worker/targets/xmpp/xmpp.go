@@ -8,6 +8,7 @@ import (
 	"github.com/mrusme/overpush/config"
 	"github.com/mrusme/overpush/models/message"
 	"github.com/mrusme/overpush/models/target"
+	"github.com/mrusme/overpush/worker/targets/acknowledge"
 	goxmpp "github.com/xmppo/go-xmpp"
 	"go.uber.org/zap"
 )
@@ -19,6 +20,8 @@ type XMPP struct {
 
 	jabberOpts goxmpp.Options
 	jabber     *goxmpp.Client
+
+	ack *acknowledge.Acknowledger
 }
 
 func New(
@@ -35,6 +38,10 @@ func New(
 	return t, nil
 }
 
+func (t *XMPP) SetAcknowledger(ack *acknowledge.Acknowledger) {
+	t.ack = ack
+}
+
 func (t *XMPP) Load() error {
 	t.log.Info("Load target: XMPP")
 	xmppServer := t.targetCfg.Args["server"].(string)
@@ -89,9 +96,59 @@ func (t *XMPP) reconnect() error {
 		return err
 	}
 
+	go t.listen(t.jabber)
+
 	return nil
 }
 
+// listen receives incoming stanzas on the given client until it is closed, in
+// order to process acknowledgements of emergency messages.
+func (t *XMPP) listen(client *goxmpp.Client) {
+	for {
+		stanza, err := client.Recv()
+		if err != nil {
+			t.log.Debug("XMPP stopped receiving",
+				zap.Error(err))
+			return
+		}
+
+		chat, ok := stanza.(goxmpp.Chat)
+		if !ok || chat.Type != "chat" || chat.Text == "" {
+			continue
+		}
+
+		t.handleReply(client, chat)
+	}
+}
+
+func bareJID(jid string) string {
+	return strings.ToLower(strings.SplitN(jid, "/", 2)[0])
+}
+
+// handleReply acknowledges pending emergency messages of the sender's bare
+// JID when it replies with an acknowledgement.
+func (t *XMPP) handleReply(client *goxmpp.Client, chat goxmpp.Chat) {
+	if t.ack == nil {
+		return
+	}
+
+	jid := bareJID(chat.Remote)
+	if text, ok := t.ack.HandleReply("xmpp:"+jid, "xmpp:"+jid, chat.Text); ok {
+		t.reply(client, chat.Remote, text)
+	}
+}
+
+func (t *XMPP) reply(client *goxmpp.Client, remote string, text string) {
+	if _, err := client.Send(goxmpp.Chat{
+		Remote: remote,
+		Type:   "chat",
+		Text:   text,
+	}); err != nil {
+		t.log.Error("XMPP failed to reply",
+			zap.Error(err))
+	}
+}
+
 func (t *XMPP) Execute(
 	m message.Message,
 	appArgs map[string]interface{},
@@ -109,10 +166,15 @@ func (t *XMPP) Execute(
 		}
 	}
 
+	text := m.ToString()
+	if m.GetReceipt() != "" && t.ack != nil {
+		text += "\n" + acknowledge.Instructions(m.GetReceipt())
+	}
+
 	_, err = t.jabber.Send(goxmpp.Chat{
 		Remote: destinationUsername,
 		Type:   "chat",
-		Text:   m.ToString(),
+		Text:   text,
 	})
 	if err != nil {
 		t.log.Error("XMPP failed to send",
@@ -120,6 +182,10 @@ func (t *XMPP) Execute(
 		return err
 	}
 
+	if m.GetReceipt() != "" && t.ack != nil {
+		t.ack.AddPending("xmpp:"+bareJID(destinationUsername), m.GetReceipt())
+	}
+
 	t.log.Debug("XMPP successfully sent message",
 		zap.String("destinationUsername", destinationUsername))
 
@@ -5,6 +5,7 @@ import (
 	"context"
 	"encoding/json"
 	"io"
+	"time"
 
 	"filippo.io/age"
 	"github.com/hibiken/asynq"
@@ -13,7 +14,9 @@ import (
 	"github.com/mrusme/overpush/helpers"
 	"github.com/mrusme/overpush/models/message"
 	"github.com/mrusme/overpush/repositories"
+	"github.com/mrusme/overpush/store"
 	"github.com/mrusme/overpush/worker/targets"
+	"github.com/mrusme/overpush/worker/targets/acknowledge"
 	"go.uber.org/zap"
 )
 
@@ -24,6 +27,7 @@ type Worker struct {
 	ts       *targets.Targets
 	redis    *asynq.Server
 	redisMux *asynq.ServeMux
+	client   *asynq.Client
 }
 
 func New(
@@ -41,6 +45,7 @@ func New(
 func (wrk *Worker) Run() error {
 	var err error
 	var db *database.Database
+	var st *store.Store
 	var repos *repositories.Repositories
 
 	if (wrk.cfg.Worker.Enable == false && wrk.cfg.Testing == true) ||
@@ -54,11 +59,21 @@ func (wrk *Worker) Run() error {
 			return (err)
 		}
 
-		if repos, err = repositories.New(wrk.cfg, db); err != nil {
+		if st, err = store.New(wrk.cfg, wrk.log); err != nil {
+			db.Shutdown()
+			return (err)
+		}
+
+		if repos, err = repositories.New(wrk.cfg, db, st); err != nil {
+			st.Shutdown()
 			db.Shutdown()
 			return (err)
 		}
 		wrk.repos = repos
+
+		if st.Enabled() == true {
+			wrk.client = asynq.NewClientFromRedisClient(st.Client())
+		}
 	}
 
 	if wrk.cfg.Worker.Enable == false || wrk.cfg.Testing == true {
@@ -79,6 +94,15 @@ func (wrk *Worker) Run() error {
 		db.Shutdown()
 		return err
 	}
+	wrk.ts.SetAcknowledger(acknowledge.New(
+		wrk.log,
+		wrk.AcknowledgeEmergency,
+		wrk.repos.Receipt,
+	))
+	wrk.ts.SetSubscriptionHandlers(
+		wrk.repos.Subscription.GetSubscriptions,
+		wrk.repos.Subscription.RemoveSubscription,
+	)
 
 	if err := wrk.ts.LoadAll(); err != nil {
 		wrk.log.Fatal("Worker failed to load targets", zap.Error(err))
@@ -92,6 +116,14 @@ func (wrk *Worker) Run() error {
 		return err
 	}
 
+	serverCfg := asynq.Config{
+		Logger:         wrk.log.Sugar(),
+		Concurrency:    wrk.cfg.Redis.Concurrency,
+		Queues:         map[string]int{store.QUEUE: 1},
+		RetryDelayFunc: wrk.retryDelay,
+		ErrorHandler:   asynq.ErrorHandlerFunc(wrk.handleError),
+	}
+
 	if wrk.cfg.Redis.Cluster == false {
 		if wrk.cfg.Redis.Failover == false {
 			wrk.redis = asynq.NewServer(
@@ -100,10 +132,7 @@ func (wrk *Worker) Run() error {
 					Username: wrk.cfg.Redis.Username,
 					Password: wrk.cfg.Redis.Password,
 				},
-				asynq.Config{
-					Logger:      wrk.log.Sugar(),
-					Concurrency: wrk.cfg.Redis.Concurrency,
-				},
+				serverCfg,
 			)
 		} else {
 			wrk.redis = asynq.NewServer(
@@ -113,10 +142,7 @@ func (wrk *Worker) Run() error {
 					Username:      wrk.cfg.Redis.Username,
 					Password:      wrk.cfg.Redis.Password,
 				},
-				asynq.Config{
-					Logger:      wrk.log.Sugar(),
-					Concurrency: wrk.cfg.Redis.Concurrency,
-				},
+				serverCfg,
 			)
 		}
 	} else {
@@ -126,15 +152,16 @@ func (wrk *Worker) Run() error {
 				Username: wrk.cfg.Redis.Username,
 				Password: wrk.cfg.Redis.Password,
 			},
-			asynq.Config{
-				Logger:      wrk.log.Sugar(),
-				Concurrency: wrk.cfg.Redis.Concurrency,
-			},
+			serverCfg,
 		)
 	}
 
 	wrk.redisMux = asynq.NewServeMux()
-	wrk.redisMux.HandleFunc("message", asynqHandler(wrk))
+	wrk.redisMux.HandleFunc(TASK_MESSAGE, asynqHandler(wrk))
+	wrk.redisMux.HandleFunc(TASK_DELIVERY, wrk.HandleDelivery)
+	wrk.redisMux.HandleFunc(TASK_CALLBACK, wrk.HandleCallback)
+	wrk.redisMux.HandleFunc(TASK_DIGEST, wrk.HandleDigest)
+	wrk.redisMux.HandleFunc(TASK_QUIET, wrk.HandleQuietHours)
 
 	if err := wrk.redis.Run(wrk.redisMux); err != nil {
 		wrk.log.Fatal("Worker failed", zap.Error(err))
@@ -153,6 +180,10 @@ func (wrk *Worker) Shutdown() error {
 
 	wrk.redis.Shutdown()
 
+	if wrk.client != nil {
+		wrk.client.Close()
+	}
+
 	if ok, errs := wrk.ts.ShutdownAll(); !ok {
 		err := helpers.ErrorsToError(errs)
 		wrk.log.Error("Worker shutdown with target errors",
@@ -167,6 +198,18 @@ func asynqHandler(wrk *Worker) func(context.Context, *asynq.Task) error {
 	return wrk.HandleMessage
 }
 
+// taskID returns the asynq task ID, which stays the same across retries of a
+// task. Tasks that are not processed by asynq (e.g. when `Testing` is enabled)
+// have no ID.
+func taskID(t *asynq.Task) string {
+	if t.ResultWriter() == nil {
+		return ""
+	}
+	return t.ResultWriter().TaskID()
+}
+
+// HandleMessage fans out a message into one delivery task per target of the
+// application, so that every target is retried and archived independently.
 func (wrk *Worker) HandleMessage(ctx context.Context, t *asynq.Task) error {
 	var m message.Message
 	if err := json.Unmarshal(t.Payload(), &m); err != nil {
@@ -175,6 +218,25 @@ func (wrk *Worker) HandleMessage(ctx context.Context, t *asynq.Task) error {
 
 	wrk.log.Debug("Working on message", zap.ByteString("payload", t.Payload()))
 
+	if m.IsExpired() == true {
+		wrk.expire(m)
+		return nil
+	}
+
+	if wrk.handlesEmergency(m) == true {
+		active, err := wrk.isEmergencyActive(m)
+		if err != nil {
+			wrk.log.Debug("Worker encountered error for emergency receipt",
+				zap.Error(err))
+			return err
+		}
+		if active == false {
+			wrk.log.Debug("Worker disregarding job, emergency not active anymore",
+				zap.String("Receipt", m.GetReceipt()))
+			return nil
+		}
+	}
+
 	app, err := wrk.repos.Application.GetApplication(m.User, m.Token)
 	if err != nil {
 		wrk.log.Debug("Worker encountered error for User.GetApplication",
@@ -191,69 +253,86 @@ func (wrk *Worker) HandleMessage(ctx context.Context, t *asynq.Task) error {
 		return nil
 	}
 
-	target, err := wrk.repos.Target.GetTargetByID(app.Target)
+	if wrk.addToDigest(app, m) == true {
+		return nil
+	}
+
+	usr, err := wrk.repos.User.GetUserFromToken(m.Token)
 	if err != nil {
-		wrk.log.Debug("Worker encountered error for Target.GetTargetByID",
+		wrk.log.Debug("Worker encountered error for User.GetUserFromToken",
 			zap.Error(err))
-		// Note: Even though this error indicates that the user target was not
-		// found, e.g. because the user changed it in the meantime, it is still
-		// worth to retry the message, if this came up due to a database related
-		// issue.
 		return err
 	}
-	if m.IsViaSubmit() == false && target.Enable == false {
-		wrk.log.Debug("Worker disregarding job, target not enabled",
-			zap.String("Target.ID", target.ID))
+
+	if wrk.holdForQuietHours(usr, app, m) == true {
 		return nil
 	}
 
-	// In the config we only store a single target's args in
-	// Application.TargetArgs; In the Database we store per-target configs:
-	// { "target1-uuid": { // config }, "target2-uuid": { // config } }
-	realTarget, ok := app.TargetArgs[target.ID]
-	if ok {
-		app.TargetArgs = realTarget.(map[string]interface{})
+	routes, err := getRoutes(app, usr, m)
+	if err != nil {
+		wrk.log.Error("Worker encountered error evaluating Application.Rules",
+			zap.String("Application.Token", app.Token),
+			zap.Error(err))
 	}
 
-	wrk.log.Debug("Worker checking encryption requirements",
-		zap.String("EncryptionType", app.EncryptionType))
-	if app.EncryptionType == "age" {
-		if err = wrk.EncryptWithAge(
-			&m,
-			app.EncryptionRecipients,
-			app.EncryptTitle,
-			app.EncryptMessage,
-			app.EncryptAttachment); err != nil {
-			wrk.log.Error("Worker encryption failed",
+	// Failing targets must not keep the remaining ones from being delivered to,
+	// which is why errors are collected and returned once all routes are done.
+	// On retry, targets that were already enqueued are skipped by task ID.
+	var delivering int = 0
+	var errs helpers.Errors = make(helpers.Errors)
+	for _, route := range routes {
+		routeID := route.TargetID
+		if route.Device != "" {
+			routeID = route.TargetID + ":" + route.Device
+		}
+
+		target, err := wrk.repos.Target.GetTargetByID(route.TargetID)
+		if err != nil {
+			wrk.log.Debug("Worker encountered error for Target.GetTargetByID",
 				zap.Error(err))
-			return err
+			// Note: Even though this error indicates that the user target was not
+			// found, e.g. because the user changed it in the meantime, it is still
+			// worth to retry the message, if this came up due to a database related
+			// issue.
+			errs[routeID] = err
+			continue
+		}
+		if m.IsViaSubmit() == false && target.Enable == false {
+			wrk.log.Debug("Worker disregarding target, target not enabled",
+				zap.String("Target.ID", target.ID))
+			continue
+		}
+
+		if err := wrk.enqueueDelivery(Delivery{
+			MessageID:  taskID(t),
+			Message:    m,
+			TargetID:   target.ID,
+			TargetArgs: route.TargetArgs,
+			Device:     route.Device,
+			StartedAt:  time.Now().Unix(),
+		}, target); err != nil {
+			errs[routeID] = err
+			continue
 		}
+		delivering++
 	}
 
-	wrk.log.Debug("Worker executing target",
-		zap.String("Target.Type", target.Type),
-		zap.Any("Target.Args", target.Args),
-		zap.Any("Application.TargetArgs", app.TargetArgs),
-	)
+	if len(errs) > 0 {
+		return helpers.ErrorsToError(errs)
+	}
 
-	if err := wrk.ts.Execute(
-		target.ID,
-		m,
-		app.TargetArgs,
-	); err != nil {
-		wrk.log.Debug("Worker target execution failed",
-			zap.Error(err))
-		return err
+	if delivering == 0 {
+		wrk.log.Debug("Worker disregarding job, no targets to deliver to",
+			zap.String("Application.Token", app.Token))
+		return nil
 	}
 
-	if m.IsViaSubmit() == false {
-		if err = wrk.repos.Application.IncrementStat(
-			"No need when DB",
-			app.Token,
-			"sent",
-		); err != nil {
-			wrk.log.Error("Application stat not increased",
-				zap.String("stat", "sent"),
+	if wrk.handlesEmergency(m) == true {
+		// Note: Returning an error here would make asynq retry the message and
+		// hence deliver it again, which is why we only log the error.
+		if err := wrk.scheduleEmergencyRedelivery(t, m); err != nil {
+			wrk.log.Error("Worker failed to schedule emergency redelivery",
+				zap.String("Receipt", m.GetReceipt()),
 				zap.Error(err))
 		}
 	}